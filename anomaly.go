@@ -0,0 +1,216 @@
+// anomaly
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var ( //anomaly
+	anomaly_score = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "anomaly",
+			Name:      "score",
+			Help:      "z-score of the value against its EWMA mean/variance.",
+		},
+		nodeLabelNames,
+	)
+)
+
+func regAnomaly() {
+	prometheus.MustRegister(anomaly_score)
+}
+
+// EWMA均值/方差
+type ewmaState struct {
+	Mean float64
+	Var  float64
+	N    int
+}
+
+// score 先用当前模型算z-score, 再把x并入模型; 方差加1避免计数很小的序列因方差接近0而误报
+func (es *ewmaState) score(x, alpha float64) float64 {
+	z := 0.0
+	if es.N > 0 {
+		z = (x - es.Mean) / math.Sqrt(es.Var+1)
+		diff := x - es.Mean
+		incr := alpha * diff
+		es.Mean += incr
+		es.Var = (1 - alpha) * (es.Var + diff*incr)
+	} else {
+		es.Mean = x
+	}
+	es.N++
+	return z
+}
+
+type anomalySeries struct {
+	Last  int64
+	Slots [24]ewmaState // seasonal时按一天中的小时分别建模, 否则只用Slots[0]
+}
+
+type anomalyStore struct {
+	mu     sync.Mutex
+	Series map[string]*anomalySeries
+}
+
+type anomalyScore struct {
+	Metric string            `json:"metric"`
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+	Mean   float64           `json:"mean"`
+	Score  float64           `json:"score"`
+	Warm   bool              `json:"warm"` // 样本数已达到warmup
+}
+
+func (st *anomalyStore) add(s sample, alpha float64, seasonal bool, warmup int) (as anomalyScore, ok bool) {
+	key := seriesKey(s.metric(), s.Labels)
+	t := s.Time.Unix()
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	ser, found := st.Series[key]
+	if !found {
+		ser = &anomalySeries{}
+		st.Series[key] = ser
+	}
+	if t <= ser.Last {
+		return as, false
+	}
+	ser.Last = t
+	es := &ser.Slots[0]
+	if seasonal {
+		es = &ser.Slots[s.Time.Local().Hour()]
+	}
+	warm := es.N >= warmup
+	mean := es.Mean
+	z := es.score(s.Value, alpha)
+	if !warm {
+		z = 0
+	}
+	return anomalyScore{
+		Metric: s.metric(),
+		Labels: labelMap(s.Labels),
+		Value:  s.Value,
+		Mean:   mean,
+		Score:  z,
+		Warm:   warm,
+	}, true
+}
+
+var (
+	anomalies     *anomalyStore
+	anomaliesSave time.Time
+	lastAnomaly   struct {
+		sync.RWMutex
+		scores []anomalyScore
+	}
+)
+
+func openAnomaly() {
+	if !globeCfg.Anomaly.Enable {
+		return
+	}
+	anomalies = &anomalyStore{Series: make(map[string]*anomalySeries)}
+	if err := loadGob(globeCfg.Anomaly.Path, anomalies); err != nil {
+		log.Println("openAnomaly:", err)
+		anomalies = &anomalyStore{Series: make(map[string]*anomalySeries)}
+	}
+	anomaliesSave = time.Now()
+	onExit(saveAnomaly)
+}
+
+func anomalyParams() (alpha, sensitivity float64, warmup int) {
+	alpha = globeCfg.Anomaly.Alpha
+	if alpha <= 0 || alpha > 1 {
+		alpha = 0.1
+	}
+	sensitivity = globeCfg.Anomaly.Sensitivity
+	if sensitivity <= 0 {
+		sensitivity = 3
+	}
+	warmup = globeCfg.Anomaly.Warmup
+	if warmup <= 0 {
+		warmup = 20
+	}
+	return
+}
+
+var anomalyHints = map[string]string{
+	"relay_invalidMsg": ", possible scanning/abuse",
+}
+
+func updateAnomaly(ss []sample) {
+	if anomalies == nil {
+		return
+	}
+	metrics := make(map[string]bool)
+	for _, m := range globeCfg.Anomaly.Metrics {
+		metrics[m] = true
+	}
+	alpha, sensitivity, warmup := anomalyParams()
+
+	scores := []anomalyScore{}
+	var firing []event
+	// 节点下线后序列不再出现, 每个周期重新设置
+	anomaly_score.Reset()
+	for _, s := range ss {
+		if !metrics[s.metric()] {
+			continue
+		}
+		as, ok := anomalies.add(s, alpha, globeCfg.Anomaly.Seasonal, warmup)
+		if !ok {
+			continue
+		}
+		scores = append(scores, as)
+		key := seriesKey(as.Metric, s.Labels)
+		if globeCfg.Output.Prometheus || globeCfg.Output.PushGateway {
+			anomaly_score.WithLabelValues(nodeLabelValues(&s)...).Set(as.Score)
+		}
+		if as.Score >= sensitivity {
+			firing = append(firing, event{
+				Key:      key,
+				Severity: "warning",
+				Message:  fmt.Sprintf("%s anomalous spike%s", key, anomalyHints[as.Metric]),
+			})
+		}
+	}
+	events.update("anomaly", firing)
+
+	lastAnomaly.Lock()
+	lastAnomaly.scores = scores
+	lastAnomaly.Unlock()
+
+	if time.Since(anomaliesSave) < time.Duration(globeCfg.Anomaly.Flush)*time.Second {
+		return
+	}
+	saveAnomaly()
+	anomaliesSave = time.Now()
+}
+
+func saveAnomaly() {
+	anomalies.mu.Lock()
+	err := saveGob(globeCfg.Anomaly.Path, anomalies)
+	anomalies.mu.Unlock()
+	if err != nil {
+		log.Println("saveAnomaly:", err)
+	}
+}
+
+// /api/v1/anomaly 最近一个周期的异常分数
+func anomalyHandler(w http.ResponseWriter, r *http.Request) {
+	lastAnomaly.RLock()
+	scores := lastAnomaly.scores
+	lastAnomaly.RUnlock()
+	if scores == nil {
+		scores = []anomalyScore{}
+	}
+	writeJSON(w, http.StatusOK, scores)
+}
@@ -0,0 +1,58 @@
+// anomaly_test
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stvp/assert"
+)
+
+func TestAnomaly(t *testing.T) {
+	st := &anomalyStore{Series: make(map[string]*anomalySeries)}
+	ls := []label{{"RelayId", "19"}}
+	base := time.Date(2017, 7, 4, 14, 0, 0, 0, time.Local)
+	add := func(i int, v float64) anomalyScore {
+		as, _ := st.add(sample{Action: "relay", Field: "invalidMsg", Labels: ls, Value: v, Time: base.Add(time.Duration(i) * time.Second)}, 0.1, false, 10)
+		return as
+	}
+	for i := 0; i < 30; i++ {
+		as := add(i, float64(10+i%3))
+		assert.True(t, as.Score < 3, "")
+	}
+	as := add(30, 200)
+	t.Log(as)
+	assert.True(t, as.Warm, "")
+	assert.True(t, as.Score > 3, "")
+
+	_, ok := st.add(sample{Action: "relay", Field: "invalidMsg", Labels: ls, Value: 1, Time: base}, 0.1, false, 10)
+	assert.False(t, ok, "")
+}
+
+func TestAnomalyGauges(t *testing.T) {
+	saved, savedOutput, savedStore := globeCfg.Anomaly, globeCfg.Output, anomalies
+	defer func() { globeCfg.Anomaly, globeCfg.Output, anomalies = saved, savedOutput, savedStore }()
+	globeCfg.Output.Prometheus = true
+	globeCfg.Anomaly.Enable = true
+	globeCfg.Anomaly.Path = filepath.Join(t.TempDir(), "anomaly.gob")
+	globeCfg.Anomaly.Metrics = []string{"cm_broken"}
+	globeCfg.Anomaly.Warmup = 1
+	globeCfg.Anomaly.Flush = 900
+	openAnomaly()
+
+	ss := cmSamples("1")
+	updateAnomaly(ss)
+	for i := range ss {
+		ss[i].Time = ss[i].Time.Add(time.Minute)
+	}
+	updateAnomaly(ss)
+	assert.Equal(t, 1, collectCount(anomaly_score), "")
+
+	// 消失的序列在下个周期删除, 未到flush间隔不落盘
+	updateAnomaly(nil)
+	assert.Equal(t, 0, collectCount(anomaly_score), "")
+	_, err := os.Stat(globeCfg.Anomaly.Path)
+	assert.True(t, os.IsNotExist(err), "")
+}
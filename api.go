@@ -0,0 +1,37 @@
+// api
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+func regApi() {
+	http.HandleFunc("/api/v1/health", healthHandler)
+	http.HandleFunc("/api/v1/topology", topologyHandler)
+	http.HandleFunc("/api/v1/impact", impactHandler)
+	http.HandleFunc("/api/v1/events", eventsHandler)
+	http.HandleFunc("/api/v1/alerts", alertsHandler)
+	http.HandleFunc("/api/v1/snapshot", snapshotHandler)
+	http.HandleFunc("/api/v1/snapshot/", snapshotHandler)
+	http.HandleFunc("/api/v1/query_range", queryRangeHandler)
+	http.HandleFunc("/api/v1/rollup", rollupHandler)
+	http.HandleFunc("/api/v1/baseline", baselineHandler)
+	http.HandleFunc("/api/v1/anomaly", anomalyHandler)
+	http.HandleFunc("/api/v1/capacity", capacityHandler)
+	http.HandleFunc("/api/v1/balance", balanceHandler)
+	http.HandleFunc("/api/v1/bandwidth", bandwidthHandler)
+	http.HandleFunc("/api/v1/dht/ring", dhtRingHandler)
+	http.HandleFunc("/api/v1/push", pushHandler)
+	http.HandleFunc("/api/v1/funnel", funnelHandler)
+	http.HandleFunc("/api/v1/sla", slaHandler)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("writeJSON:", err)
+	}
+}
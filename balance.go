@@ -0,0 +1,345 @@
+// balance
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var ( //balance
+	balance_imbalance = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "balance",
+			Name:      "imbalance",
+			Help:      "coefficient of variation of relay load in the group, 0 is balanced.",
+		},
+		[]string{
+			"Group",
+		},
+	)
+	balance_load = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "balance",
+			Name:      "load",
+			Help:      "relay load relative to group mean, 1 is average.",
+		},
+		[]string{
+			"RelayId",
+			"Group",
+		},
+	)
+)
+
+func regBalance() {
+	prometheus.MustRegister(balance_imbalance)
+	prometheus.MustRegister(balance_load)
+}
+
+const (
+	relayNormal     = "normal"
+	relayIdle       = "idle"
+	relayOverloaded = "overloaded"
+)
+
+type relayLoad struct {
+	RelayID   string  `json:"relayId"`
+	IP        string  `json:"ip"`
+	Port      string  `json:"port"`
+	Group     string  `json:"group"`
+	Healthy   bool    `json:"healthy"`
+	Onphone   float64 `json:"onphone"`
+	Onconnect float64 `json:"onconnect"`
+	Traffic   float64 `json:"traffic"` // upStream+downStream
+	Load      float64 `json:"load"`
+	State     string  `json:"state"`
+}
+
+type relayMove struct {
+	Group string `json:"group"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Users int    `json:"users"` // 建议迁移的接入用户数
+}
+
+type balanceGroup struct {
+	Group     string       `json:"group"`
+	Imbalance float64      `json:"imbalance"`
+	Relays    []*relayLoad `json:"relays"`
+}
+
+type balanceReport struct {
+	GroupBy    string          `json:"groupBy,omitempty"`
+	Overload   float64         `json:"overload"`
+	Groups     []*balanceGroup `json:"groups"`
+	Idle       []string        `json:"idle"`
+	Overloaded []string        `json:"overloaded"`
+	Moves      []*relayMove    `json:"moves"`
+}
+
+// relayGroup 按配置的region/isp给relay分组, 未配置的归入unknown
+func relayGroup(relayID, groupBy string) string {
+	if groupBy == "" {
+		return "all"
+	}
+	for _, m := range globeCfg.Balance.Relays {
+		if m.Id != relayID {
+			continue
+		}
+		switch groupBy {
+		case "region":
+			return m.Region
+		case "isp":
+			return m.Isp
+		default:
+			return m.Region + "/" + m.Isp
+		}
+	}
+	return "unknown"
+}
+
+func balanceParams() (groupBy string, overload float64) {
+	groupBy = globeCfg.Balance.GroupBy
+	if groupBy != "region" && groupBy != "isp" && groupBy != "region/isp" {
+		groupBy = ""
+	}
+	overload = globeCfg.Balance.Overload
+	if overload <= 1 {
+		overload = 1.5
+	}
+	return
+}
+
+// scoreGroup 每个relay的负载为各指标相对组内均值之比的平均, 不健康的relay不参与均值
+func scoreGroup(g *balanceGroup, overload float64) {
+	metrics := []func(*relayLoad) float64{
+		func(r *relayLoad) float64 { return r.Onphone },
+		func(r *relayLoad) float64 { return r.Onconnect },
+		func(r *relayLoad) float64 { return r.Traffic },
+	}
+	means := make([]float64, len(metrics))
+	n := 0
+	for _, r := range g.Relays {
+		if !r.Healthy {
+			continue
+		}
+		n++
+		for i, m := range metrics {
+			means[i] += m(r)
+		}
+	}
+	for i := range means {
+		if n > 0 {
+			means[i] /= float64(n)
+		}
+	}
+
+	var sum, sq float64
+	for _, r := range g.Relays {
+		k := 0
+		r.Load = 0
+		for i, m := range metrics {
+			if means[i] > 0 {
+				r.Load += m(r) / means[i]
+				k++
+			}
+		}
+		if k > 0 {
+			r.Load /= float64(k)
+		}
+		switch {
+		case r.Onphone == 0 && r.Onconnect == 0 && r.Traffic == 0:
+			r.State = relayIdle
+		case r.Load >= overload:
+			r.State = relayOverloaded
+		default:
+			r.State = relayNormal
+		}
+		if r.Healthy {
+			sum += r.Load
+			sq += r.Load * r.Load
+		}
+	}
+	if n > 0 && sum > 0 {
+		mean := sum / float64(n)
+		g.Imbalance = math.Sqrt(math.Max(sq/float64(n)-mean*mean, 0)) / mean
+	}
+}
+
+// planMoves 把过载relay超过组内均值的接入用户迁到低于均值的健康relay
+func planMoves(g *balanceGroup) []*relayMove {
+	var total float64
+	var healthy []*relayLoad
+	for _, r := range g.Relays {
+		if r.Healthy {
+			total += r.Onconnect
+			healthy = append(healthy, r)
+		}
+	}
+	if len(healthy) < 2 {
+		return nil
+	}
+	mean := total / float64(len(healthy))
+	var from, to []*relayLoad
+	for _, r := range healthy {
+		if r.State == relayOverloaded && r.Onconnect > mean {
+			from = append(from, r)
+		} else if r.Onconnect < mean {
+			to = append(to, r)
+		}
+	}
+	sort.Slice(from, func(i, j int) bool { return from[i].Onconnect > from[j].Onconnect })
+	sort.Slice(to, func(i, j int) bool { return to[i].Onconnect < to[j].Onconnect })
+
+	var moves []*relayMove
+	deficit := make([]float64, len(to))
+	for i, r := range to {
+		deficit[i] = mean - r.Onconnect
+	}
+	for _, f := range from {
+		excess := f.Onconnect - mean
+		for i, t := range to {
+			if excess < 1 {
+				break
+			}
+			n := math.Floor(math.Min(excess, deficit[i]))
+			if n < 1 {
+				continue
+			}
+			moves = append(moves, &relayMove{Group: g.Group, From: f.RelayID, To: t.RelayID, Users: int(n)})
+			excess -= n
+			deficit[i] -= n
+		}
+	}
+	return moves
+}
+
+func buildBalance() *balanceReport {
+	groupBy, overload := balanceParams()
+	br := &balanceReport{GroupBy: groupBy, Overload: overload, Groups: []*balanceGroup{}, Idle: []string{}, Overloaded: []string{}, Moves: []*relayMove{}}
+
+	healthy := make(map[string]bool)
+	for _, rec := range lastCycle.records("serverSummary") {
+		if rec[2] == "8" {
+			healthy[rec[1]] = rec[7] == "1"
+		}
+	}
+
+	groups := make(map[string]*balanceGroup)
+	//  时间 relay节点id relay IP relay Port 并发通话数 接入|落地用户数 ... 上行流量 下行流量
+	for _, rec := range lastCycle.records("relay") {
+		num := func(i int) float64 {
+			v, _ := strconv.ParseFloat(rec[i], 64)
+			return v
+		}
+		h, ok := healthy[rec[1]]
+		r := &relayLoad{
+			RelayID:   rec[1],
+			IP:        rec[2],
+			Port:      rec[3],
+			Group:     relayGroup(rec[1], groupBy),
+			Healthy:   h || !ok,
+			Onphone:   num(4),
+			Onconnect: num(5),
+			Traffic:   num(12) + num(13),
+		}
+		g, ok := groups[r.Group]
+		if !ok {
+			g = &balanceGroup{Group: r.Group}
+			groups[r.Group] = g
+			br.Groups = append(br.Groups, g)
+		}
+		g.Relays = append(g.Relays, r)
+	}
+	sort.Slice(br.Groups, func(i, j int) bool { return br.Groups[i].Group < br.Groups[j].Group })
+
+	for _, g := range br.Groups {
+		scoreGroup(g, overload)
+		for _, r := range g.Relays {
+			switch r.State {
+			case relayIdle:
+				br.Idle = append(br.Idle, r.RelayID)
+			case relayOverloaded:
+				br.Overloaded = append(br.Overloaded, r.RelayID)
+			}
+		}
+		br.Moves = append(br.Moves, planMoves(g)...)
+	}
+	return br
+}
+
+func (br *balanceReport) events() []event {
+	var evs []event
+	for _, g := range br.Groups {
+		for _, r := range g.Relays {
+			switch r.State {
+			case relayIdle:
+				evs = append(evs, event{
+					Key:      "relay:" + r.RelayID,
+					Severity: "info",
+					Message:  fmt.Sprintf("relay %s (%s:%s) is idle", r.RelayID, r.IP, r.Port),
+					Nodes:    []string{topoID("relay", r.RelayID)},
+				})
+			case relayOverloaded:
+				evs = append(evs, event{
+					Key:      "relay:" + r.RelayID,
+					Severity: "warning",
+					Message:  fmt.Sprintf("relay %s (%s:%s) overloaded in group %s", r.RelayID, r.IP, r.Port, g.Group),
+					Nodes:    []string{topoID("relay", r.RelayID)},
+				})
+			}
+		}
+	}
+	return evs
+}
+
+func updateBalance() {
+	br := buildBalance()
+	if globeCfg.Output.Prometheus || globeCfg.Output.PushGateway {
+		// relay下线或换组后不再出现, 每个周期重新设置
+		balance_imbalance.Reset()
+		balance_load.Reset()
+		for _, g := range br.Groups {
+			balance_imbalance.WithLabelValues(g.Group).Set(g.Imbalance)
+			for _, r := range g.Relays {
+				balance_load.WithLabelValues(r.RelayID, g.Group).Set(r.Load)
+			}
+		}
+	}
+	events.update("balance", br.events())
+}
+
+func (br *balanceReport) writeText(w io.Writer) {
+	for _, g := range br.Groups {
+		fmt.Fprintf(w, "group %s imbalance %.2f\n", g.Group, g.Imbalance)
+		for _, r := range g.Relays {
+			health := ""
+			if !r.Healthy {
+				health = " unhealthy"
+			}
+			fmt.Fprintf(w, "  relay %-6s %s:%s onphone %.0f onconnect %.0f traffic %.0f load %.2f %s%s\n",
+				r.RelayID, r.IP, r.Port, r.Onphone, r.Onconnect, r.Traffic, r.Load, r.State, health)
+		}
+	}
+	for _, m := range br.Moves {
+		fmt.Fprintf(w, "move ~%d users from relay %s to relay %s (group %s)\n", m.Users, m.From, m.To, m.Group)
+	}
+}
+
+// /api/v1/balance, ?format=text
+func balanceHandler(w http.ResponseWriter, r *http.Request) {
+	br := buildBalance()
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		br.writeText(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, br)
+}
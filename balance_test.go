@@ -0,0 +1,61 @@
+// balance_test
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stvp/assert"
+)
+
+func TestBuildBalance(t *testing.T) {
+	commitRecords("serverSummary",
+		"2017.07.04 14:45:41.639|14|8|223.111.205.85|9000|0|1|1",
+		"2017.07.04 14:45:41.639|15|8|223.111.205.87|9000|0|1|1",
+		"2017.07.04 14:45:41.639|16|8|223.111.205.88|9000|0|1|1",
+		"2017.07.04 14:45:41.639|21|8|223.111.205.86|9000|0|0|0",
+	)
+	commitRecords("relay",
+		"2017.07.04 14:45:41.639|14|223.111.205.85|9000|90|300|0|0|0|0|0|0|9000|9000",
+		"2017.07.04 14:45:41.639|15|223.111.205.87|9000|10|50|0|0|0|0|0|0|1000|1000",
+		"2017.07.04 14:45:41.639|16|223.111.205.88|9000|20|100|0|0|0|0|0|0|2000|2000",
+		"2017.07.04 14:45:41.639|21|223.111.205.86|9000|0|0|0|0|0|0|0|0|0|0",
+	)
+	br := buildBalance()
+	assert.Equal(t, 1, len(br.Groups), "")
+	assert.Equal(t, "all", br.Groups[0].Group, "")
+	assert.True(t, br.Groups[0].Imbalance > 0.5, "")
+	assert.Equal(t, []string{"21"}, br.Idle, "")
+	assert.Equal(t, []string{"14"}, br.Overloaded, "")
+
+	// 均值150, relay 14多出的150个用户先迁到最空的15, 再到16
+	assert.Equal(t, 2, len(br.Moves), "")
+	assert.Equal(t, relayMove{Group: "all", From: "14", To: "15", Users: 100}, *br.Moves[0], "")
+	assert.Equal(t, relayMove{Group: "all", From: "14", To: "16", Users: 50}, *br.Moves[1], "")
+
+	var b strings.Builder
+	br.writeText(&b)
+	assert.True(t, strings.Contains(b.String(), "move ~100 users from relay 14 to relay 15"), "")
+	assert.True(t, strings.Contains(b.String(), "idle unhealthy"), "")
+}
+
+func TestBalanceGauges(t *testing.T) {
+	saved := globeCfg.Output
+	defer func() { globeCfg.Output = saved }()
+	globeCfg.Output.Prometheus = true
+	commitRecords("serverSummary")
+	commitRecords("relay",
+		"2017.07.04 14:45:41.639|14|223.111.205.85|9000|90|300|0|0|0|0|0|0|9000|9000",
+		"2017.07.04 14:45:41.639|15|223.111.205.87|9000|10|50|0|0|0|0|0|0|1000|1000",
+	)
+	updateBalance()
+	assert.Equal(t, 2, collectCount(balance_load), "")
+
+	// 下线的relay不再导出
+	commitRecords("relay",
+		"2017.07.04 14:48:41.639|14|223.111.205.85|9000|90|300|0|0|0|0|0|0|9000|9000",
+	)
+	updateBalance()
+	assert.Equal(t, 1, collectCount(balance_load), "")
+	assert.Equal(t, 1, collectCount(balance_imbalance), "")
+}
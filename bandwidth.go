@@ -0,0 +1,316 @@
+// bandwidth
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var ( //bandwidth
+	bandwidth_bits = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "bandwidth",
+			Name:      "bits",
+			Help:      "relay media forwarding bandwidth in bits/s.",
+		},
+		[]string{
+			"RelayId",
+			"IP",
+			"Port",
+			"Direction",
+		},
+	)
+)
+
+func regBandwidth() {
+	prometheus.MustRegister(bandwidth_bits)
+}
+
+// relay上下行流量的单位换算为bits/s的系数, 3min表示3分钟内的总量
+var bandwidthUnits = map[string]float64{
+	"b/s":     1,
+	"kb/s":    1e3,
+	"mb/s":    1e6,
+	"B/s":     8,
+	"KB/s":    8e3,
+	"MB/s":    8e6,
+	"B/3min":  8.0 / 180,
+	"KB/3min": 8e3 / 180,
+	"MB/3min": 8e6 / 180,
+}
+
+const (
+	bandwidthWindow = 180 // 秒, 每个值代表最近3分钟
+	periodMonth     = "month"
+)
+
+type bwPoint struct {
+	T    int64
+	Up   float64 // bits/s
+	Down float64
+}
+
+type bwRelay struct {
+	IP     string
+	Port   string
+	Points []bwPoint
+}
+
+type bandwidthStore struct {
+	mu     sync.RWMutex
+	Relays map[string]*bwRelay
+}
+
+func (st *bandwidthStore) add(relayID, ip, port string, p bwPoint, keepFrom int64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	r, ok := st.Relays[relayID]
+	if !ok {
+		r = &bwRelay{}
+		st.Relays[relayID] = r
+	}
+	r.IP, r.Port = ip, port
+	if n := len(r.Points); n > 0 && p.T <= r.Points[n-1].T {
+		return
+	}
+	r.Points = append(r.Points, p)
+	i := 0
+	for i < len(r.Points) && r.Points[i].T < keepFrom {
+		i++
+	}
+	r.Points = r.Points[i:]
+}
+
+// billingStart 计费周期的起点, 每月billingDay日0点
+func billingStart(t time.Time, billingDay int) time.Time {
+	t = t.Local()
+	start := time.Date(t.Year(), t.Month(), billingDay, 0, 0, 0, 0, time.Local)
+	if start.After(t) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start
+}
+
+// percentile95 去掉最高5%后的最大值
+func percentile95(vs []float64) float64 {
+	if len(vs) == 0 {
+		return 0
+	}
+	s := append([]float64{}, vs...)
+	sort.Float64s(s)
+	i := int(math.Ceil(0.95*float64(len(s)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return s[i]
+}
+
+type bandwidthRow struct {
+	RelayID   string    `json:"relayId"`
+	IP        string    `json:"ip"`
+	Port      string    `json:"port"`
+	Start     time.Time `json:"start"`
+	Samples   int       `json:"samples"`
+	UpBytes   float64   `json:"upBytes"`
+	DownBytes float64   `json:"downBytes"`
+	P95Up     float64   `json:"p95Up"`   // bits/s
+	P95Down   float64   `json:"p95Down"` // bits/s
+	P95       float64   `json:"p95"`     // max(上行, 下行)的95值, 一般按此计费
+}
+
+// report 按天或计费周期汇总[from, to), 流量按相邻两个点的间隔(不超过3分钟)累计
+func (st *bandwidthStore) report(period string, billingDay int, from, to time.Time) []*bandwidthRow {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	ids := make([]string, 0, len(st.Relays))
+	for id := range st.Relays {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, _ := strconv.Atoi(ids[i])
+		b, _ := strconv.Atoi(ids[j])
+		return a < b
+	})
+
+	rows := []*bandwidthRow{}
+	for _, id := range ids {
+		r := st.Relays[id]
+		var row *bandwidthRow
+		var ups, downs, maxs []float64
+		flush := func() {
+			if row != nil {
+				row.P95Up, row.P95Down, row.P95 = percentile95(ups), percentile95(downs), percentile95(maxs)
+				rows = append(rows, row)
+			}
+			ups, downs, maxs = nil, nil, nil
+		}
+		var prev int64
+		for _, p := range r.Points {
+			t := time.Unix(p.T, 0)
+			if t.Before(from) || !t.Before(to) {
+				prev = p.T
+				continue
+			}
+			start := time.Unix(periodStart(t, periodDay), 0)
+			if period == periodMonth {
+				start = billingStart(t, billingDay)
+			}
+			if row == nil || !row.Start.Equal(start) {
+				flush()
+				row = &bandwidthRow{RelayID: id, IP: r.IP, Port: r.Port, Start: start}
+			}
+			secs := float64(bandwidthWindow)
+			if prev > 0 && p.T-prev < bandwidthWindow {
+				secs = float64(p.T - prev)
+			}
+			prev = p.T
+			row.Samples++
+			row.UpBytes += p.Up * secs / 8
+			row.DownBytes += p.Down * secs / 8
+			ups = append(ups, p.Up)
+			downs = append(downs, p.Down)
+			maxs = append(maxs, math.Max(p.Up, p.Down))
+		}
+		flush()
+	}
+	return rows
+}
+
+var (
+	bandwidths     *bandwidthStore
+	bandwidthsSave time.Time
+)
+
+func bandwidthParams() (factor float64, billingDay int, retention time.Duration) {
+	factor, ok := bandwidthUnits[globeCfg.Bandwidth.Unit]
+	if !ok {
+		factor = 8
+	}
+	billingDay = globeCfg.Bandwidth.BillingDay
+	if billingDay < 1 || billingDay > 28 {
+		billingDay = 1
+	}
+	months := globeCfg.Bandwidth.Retention
+	if months <= 0 {
+		months = 3
+	}
+	retention = time.Duration(months+1) * 31 * 24 * time.Hour
+	return
+}
+
+func openBandwidth() {
+	if !globeCfg.Bandwidth.Enable {
+		return
+	}
+	if _, ok := bandwidthUnits[globeCfg.Bandwidth.Unit]; !ok {
+		log.Println("openBandwidth: unknown unit", globeCfg.Bandwidth.Unit, "use B/s")
+	}
+	bandwidths = &bandwidthStore{Relays: make(map[string]*bwRelay)}
+	if err := loadGob(globeCfg.Bandwidth.Path, bandwidths); err != nil {
+		log.Println("openBandwidth:", err)
+		bandwidths = &bandwidthStore{Relays: make(map[string]*bwRelay)}
+	}
+	bandwidthsSave = time.Now()
+	onExit(saveBandwidth)
+}
+
+func updateBandwidth() {
+	factor, _, retention := bandwidthParams()
+	// relay下线后不再出现, 每个周期重新设置
+	if globeCfg.Output.Prometheus || globeCfg.Output.PushGateway {
+		bandwidth_bits.Reset()
+	}
+	//  时间 relay节点id relay IP relay Port ... *上行流量 *下行流量
+	for _, rec := range lastCycle.records("relay") {
+		t, ok := recordTime(rec)
+		if !ok {
+			continue
+		}
+		up, _ := strconv.ParseFloat(rec[12], 64)
+		down, _ := strconv.ParseFloat(rec[13], 64)
+		p := bwPoint{T: t.Unix(), Up: up * factor, Down: down * factor}
+		if globeCfg.Output.Prometheus || globeCfg.Output.PushGateway {
+			bandwidth_bits.WithLabelValues(rec[1], rec[2], rec[3], "up").Set(p.Up)
+			bandwidth_bits.WithLabelValues(rec[1], rec[2], rec[3], "down").Set(p.Down)
+		}
+		if bandwidths != nil {
+			bandwidths.add(rec[1], rec[2], rec[3], p, t.Add(-retention).Unix())
+		}
+	}
+
+	if bandwidths == nil || time.Since(bandwidthsSave) < time.Duration(globeCfg.Bandwidth.Flush)*time.Second {
+		return
+	}
+	saveBandwidth()
+	bandwidthsSave = time.Now()
+}
+
+func saveBandwidth() {
+	bandwidths.mu.RLock()
+	err := saveGob(globeCfg.Bandwidth.Path, bandwidths)
+	bandwidths.mu.RUnlock()
+	if err != nil {
+		log.Println("saveBandwidth:", err)
+	}
+}
+
+func writeBandwidthCSV(w io.Writer, rows []*bandwidthRow) {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"relayId", "ip", "port", "period", "samples", "upBytes", "downBytes", "p95UpBits", "p95DownBits", "p95Bits"})
+	ff := func(v float64) string { return strconv.FormatFloat(v, 'f', 0, 64) }
+	for _, r := range rows {
+		cw.Write([]string{r.RelayID, r.IP, r.Port, r.Start.Format("2006-01-02"), strconv.Itoa(r.Samples),
+			ff(r.UpBytes), ff(r.DownBytes), ff(r.P95Up), ff(r.P95Down), ff(r.P95)})
+	}
+	cw.Flush()
+}
+
+// /api/v1/bandwidth?period=day|month&from=&to=&format=csv, month为计费周期
+func bandwidthHandler(w http.ResponseWriter, r *http.Request) {
+	if bandwidths == nil {
+		http.Error(w, "bandwidth disabled", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	period := strings.ToLower(q.Get("period"))
+	if period == "" {
+		period = periodMonth
+	}
+	if period != periodDay && period != periodMonth {
+		http.Error(w, "invalid period", http.StatusBadRequest)
+		return
+	}
+	_, billingDay, _ := bandwidthParams()
+	now := time.Now()
+	to, err := parseAPIEnd(q.Get("to"), now)
+	if err != nil {
+		http.Error(w, "invalid to", http.StatusBadRequest)
+		return
+	}
+	// to不含在区间内, 默认from取to之前那一刻所在的计费周期
+	from, err := parseAPITime(q.Get("from"), billingStart(to.Add(-time.Second), billingDay))
+	if err != nil {
+		http.Error(w, "invalid from", http.StatusBadRequest)
+		return
+	}
+	rows := bandwidths.report(period, billingDay, from, to)
+	if q.Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=bandwidth-%s-%s.csv", period, from.Format("20060102")))
+		writeBandwidthCSV(w, rows)
+		return
+	}
+	writeJSON(w, http.StatusOK, rows)
+}
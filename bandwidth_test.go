@@ -0,0 +1,90 @@
+// bandwidth_test
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stvp/assert"
+)
+
+func TestPercentile95(t *testing.T) {
+	var vs []float64
+	for i := 1; i <= 100; i++ {
+		vs = append(vs, float64(i))
+	}
+	assert.Equal(t, 95.0, percentile95(vs), "")
+	assert.Equal(t, 7.0, percentile95([]float64{7}), "")
+	assert.Equal(t, 0.0, percentile95(nil), "")
+}
+
+func TestBandwidthReport(t *testing.T) {
+	st := &bandwidthStore{Relays: make(map[string]*bwRelay)}
+	base := time.Date(2017, 7, 4, 12, 0, 0, 0, time.Local)
+	for i := 0; i < 40; i++ {
+		p := bwPoint{T: base.Add(time.Duration(i) * 3 * time.Minute).Unix(), Up: 8000, Down: 800}
+		if i == 39 {
+			p.Up = 80000
+		}
+		st.add("14", "223.111.205.85", "9000", p, 0)
+		st.add("14", "223.111.205.85", "9000", p, 0)
+	}
+	st.add("14", "223.111.205.85", "9000", bwPoint{T: base.AddDate(0, 0, 1).Unix(), Up: 16000, Down: 0}, 0)
+
+	days := st.report(periodDay, 1, base.AddDate(0, 0, -1), base.AddDate(0, 0, 2))
+	assert.Equal(t, 2, len(days), "")
+	assert.Equal(t, 40, days[0].Samples, "")
+	assert.Equal(t, 8000.0, days[0].P95Up, "")
+	assert.Equal(t, 8000.0, days[0].P95, "")
+	assert.Equal(t, (39*8000+80000)*180/8.0, days[0].UpBytes, "")
+	assert.Equal(t, 40*800*180/8.0, days[0].DownBytes, "")
+
+	months := st.report(periodMonth, 1, base.AddDate(0, -1, 0), base.AddDate(0, 0, 2))
+	assert.Equal(t, 1, len(months), "")
+	assert.Equal(t, 41, months[0].Samples, "")
+	assert.Equal(t, time.Date(2017, 7, 1, 0, 0, 0, 0, time.Local), months[0].Start, "")
+
+	assert.Equal(t, time.Date(2017, 6, 15, 0, 0, 0, 0, time.Local), billingStart(base, 15), "")
+}
+
+func TestBandwidthHandlerDates(t *testing.T) {
+	saved, savedCfg := bandwidths, globeCfg.Bandwidth
+	defer func() { bandwidths, globeCfg.Bandwidth = saved, savedCfg }()
+	globeCfg.Bandwidth.BillingDay = 1
+	bandwidths = &bandwidthStore{Relays: make(map[string]*bwRelay)}
+	last := time.Date(2017, 7, 31, 12, 0, 0, 0, time.Local)
+	bandwidths.add("14", "223.111.205.85", "9000", bwPoint{T: last.Unix(), Up: 8000, Down: 800}, 0)
+	bandwidths.add("14", "223.111.205.85", "9000", bwPoint{T: last.Add(3 * time.Minute).Unix(), Up: 8000, Down: 800}, 0)
+	bandwidths.add("14", "223.111.205.85", "9000", bwPoint{T: time.Date(2017, 8, 1, 0, 0, 0, 0, time.Local).Unix(), Up: 8000, Down: 800}, 0)
+
+	// to只给日期时包含这一天, 默认from为该计费周期开始
+	w := httptest.NewRecorder()
+	bandwidthHandler(w, httptest.NewRequest("GET", "/api/v1/bandwidth?to=2017-07-31", nil))
+	var rows []*bandwidthRow
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &rows), "")
+	assert.Equal(t, 1, len(rows), "")
+	assert.True(t, rows[0].Start.Equal(time.Date(2017, 7, 1, 0, 0, 0, 0, time.Local)), "")
+	assert.Equal(t, 2, rows[0].Samples, "")
+}
+
+func TestBandwidthGauges(t *testing.T) {
+	saved, savedOutput := bandwidths, globeCfg.Output
+	defer func() { bandwidths, globeCfg.Output = saved, savedOutput }()
+	bandwidths = nil
+	globeCfg.Output.Prometheus = true
+	commitRecords("relay",
+		"2017.07.04 14:45:41.639|14|223.111.205.85|9000|90|300|0|0|0|0|0|0|9000|9000",
+		"2017.07.04 14:45:41.639|15|223.111.205.87|9000|10|50|0|0|0|0|0|0|1000|1000",
+	)
+	updateBandwidth()
+	assert.Equal(t, 4, collectCount(bandwidth_bits), "")
+
+	// 下线的relay不再导出
+	commitRecords("relay",
+		"2017.07.04 14:48:41.639|14|223.111.205.85|9000|90|300|0|0|0|0|0|0|9000|9000",
+	)
+	updateBandwidth()
+	assert.Equal(t, 2, collectCount(bandwidth_bits), "")
+}
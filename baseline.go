@@ -0,0 +1,252 @@
+// baseline
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var ( //baseline
+	baseline_value = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "baseline",
+			Name:      "value",
+			Help:      "baseline of the same hour of week.",
+		},
+		nodeLabelNames,
+	)
+	baseline_deviation = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "baseline",
+			Name:      "deviation",
+			Help:      "(value-baseline)/baseline.",
+		},
+		nodeLabelNames,
+	)
+)
+
+func regBaseline() {
+	prometheus.MustRegister(baseline_value)
+	prometheus.MustRegister(baseline_deviation)
+}
+
+const hoursOfWeek = 7 * 24
+
+type baselineSlot struct {
+	Mean  float64 // 各周同一小时均值的EWMA
+	Weeks int
+}
+
+type baselineSeries struct {
+	Metric string
+	Labels []label
+	Slots  [hoursOfWeek]baselineSlot
+	Last   int64
+
+	// 当前小时的累计, 小时结束时并入Slots
+	Hour      int64
+	HourSum   float64
+	HourCount int
+}
+
+type baselineStore struct {
+	mu     sync.RWMutex
+	Series map[string]*baselineSeries
+}
+
+func hourOfWeek(t time.Time) int {
+	t = t.Local()
+	return int(t.Weekday())*24 + t.Hour()
+}
+
+var weekdayNames = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+func hourOfWeekName(h int) string {
+	return fmt.Sprintf("%s %02d:00", weekdayNames[h/24], h%24)
+}
+
+// fold 上一个小时的均值并入其所在的hour-of-week
+func (bs *baselineSeries) fold(alpha float64) {
+	if bs.HourCount == 0 {
+		return
+	}
+	avg := bs.HourSum / float64(bs.HourCount)
+	slot := &bs.Slots[hourOfWeek(time.Unix(bs.Hour, 0))]
+	if slot.Weeks == 0 {
+		slot.Mean = avg
+	} else {
+		slot.Mean = alpha*avg + (1-alpha)*slot.Mean
+	}
+	slot.Weeks++
+	bs.HourSum, bs.HourCount = 0, 0
+}
+
+type baselineDeviation struct {
+	Metric     string            `json:"metric"`
+	Labels     map[string]string `json:"labels"`
+	Value      float64           `json:"value"`
+	Baseline   float64           `json:"baseline"`
+	Deviation  float64           `json:"deviation"`
+	HourOfWeek string            `json:"hourOfWeek"`
+	Weeks      int               `json:"weeks"`
+}
+
+// add 更新基线并返回当前值相对同一hour-of-week基线的偏离, 基线样本不足minWeeks时ok为false
+func (st *baselineStore) add(s sample, alpha float64, minWeeks int) (d baselineDeviation, ok bool) {
+	key := seriesKey(s.metric(), s.Labels)
+	t := s.Time.Unix()
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	bs, found := st.Series[key]
+	if !found {
+		bs = &baselineSeries{Metric: s.metric(), Labels: s.Labels}
+		st.Series[key] = bs
+	}
+	if t <= bs.Last {
+		return d, false
+	}
+	bs.Last = t
+	hour := periodStart(s.Time, periodHour)
+	if hour != bs.Hour {
+		bs.fold(alpha)
+		bs.Hour = hour
+	}
+	bs.HourSum += s.Value
+	bs.HourCount++
+
+	how := hourOfWeek(s.Time)
+	slot := bs.Slots[how]
+	if slot.Weeks < minWeeks || slot.Mean <= 0 {
+		return d, false
+	}
+	return baselineDeviation{
+		Metric:     bs.Metric,
+		Labels:     labelMap(bs.Labels),
+		Value:      s.Value,
+		Baseline:   slot.Mean,
+		Deviation:  (s.Value - slot.Mean) / slot.Mean,
+		HourOfWeek: hourOfWeekName(how),
+		Weeks:      slot.Weeks,
+	}, true
+}
+
+var (
+	baselines     *baselineStore
+	baselinesSave time.Time
+	lastDeviation struct {
+		sync.RWMutex
+		devs []baselineDeviation
+	}
+)
+
+func openBaseline() {
+	if !globeCfg.Baseline.Enable {
+		return
+	}
+	baselines = &baselineStore{Series: make(map[string]*baselineSeries)}
+	if err := loadGob(globeCfg.Baseline.Path, baselines); err != nil {
+		log.Println("openBaseline:", err)
+		baselines = &baselineStore{Series: make(map[string]*baselineSeries)}
+	}
+	baselinesSave = time.Now()
+	onExit(saveBaseline)
+}
+
+func baselineParams() (alpha float64, minWeeks int, threshold float64) {
+	alpha = globeCfg.Baseline.Alpha
+	if alpha <= 0 || alpha > 1 {
+		alpha = 0.3
+	}
+	minWeeks = globeCfg.Baseline.MinWeeks
+	if minWeeks <= 0 {
+		minWeeks = 2
+	}
+	threshold = globeCfg.Baseline.Threshold
+	if threshold <= 0 {
+		threshold = 0.3
+	}
+	return
+}
+
+func updateBaseline(ss []sample) {
+	if baselines == nil {
+		return
+	}
+	metrics := make(map[string]bool)
+	for _, m := range globeCfg.Baseline.Metrics {
+		metrics[m] = true
+	}
+	alpha, minWeeks, threshold := baselineParams()
+
+	devs := []baselineDeviation{}
+	var firing []event
+	// 节点下线后序列不再出现, 每个周期重新设置
+	baseline_value.Reset()
+	baseline_deviation.Reset()
+	for _, s := range ss {
+		if !metrics[s.metric()] {
+			continue
+		}
+		d, ok := baselines.add(s, alpha, minWeeks)
+		if !ok {
+			continue
+		}
+		devs = append(devs, d)
+		key := seriesKey(d.Metric, s.Labels)
+		if globeCfg.Output.Prometheus || globeCfg.Output.PushGateway {
+			baseline_value.WithLabelValues(nodeLabelValues(&s)...).Set(d.Baseline)
+			baseline_deviation.WithLabelValues(nodeLabelValues(&s)...).Set(d.Deviation)
+		}
+		if math.Abs(d.Deviation) >= threshold {
+			dir := "above"
+			if d.Deviation < 0 {
+				dir = "below"
+			}
+			firing = append(firing, event{
+				Key:      key,
+				Severity: "warning",
+				Message:  fmt.Sprintf("%s more than %.0f%% %s baseline of %s", key, threshold*100, dir, d.HourOfWeek),
+			})
+		}
+	}
+	events.update("baseline", firing)
+
+	lastDeviation.Lock()
+	lastDeviation.devs = devs
+	lastDeviation.Unlock()
+
+	if time.Since(baselinesSave) < time.Duration(globeCfg.Baseline.Flush)*time.Second {
+		return
+	}
+	saveBaseline()
+	baselinesSave = time.Now()
+}
+
+func saveBaseline() {
+	baselines.mu.RLock()
+	err := saveGob(globeCfg.Baseline.Path, baselines)
+	baselines.mu.RUnlock()
+	if err != nil {
+		log.Println("saveBaseline:", err)
+	}
+}
+
+// /api/v1/baseline 最近一个周期各序列相对基线的偏离
+func baselineHandler(w http.ResponseWriter, r *http.Request) {
+	lastDeviation.RLock()
+	devs := lastDeviation.devs
+	lastDeviation.RUnlock()
+	if devs == nil {
+		devs = []baselineDeviation{}
+	}
+	writeJSON(w, http.StatusOK, devs)
+}
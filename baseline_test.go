@@ -0,0 +1,62 @@
+// baseline_test
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stvp/assert"
+)
+
+func collectCount(c prometheus.Collector) int {
+	ch := make(chan prometheus.Metric, 100)
+	c.Collect(ch)
+	close(ch)
+	return len(ch)
+}
+
+func TestBaseline(t *testing.T) {
+	st := &baselineStore{Series: make(map[string]*baselineSeries)}
+	monday := time.Date(2017, 7, 3, 9, 0, 0, 0, time.Local)
+	add := func(tm time.Time, v float64) (baselineDeviation, bool) {
+		return st.add(sample{Action: "userStatistic", Field: "login", Value: v, Time: tm}, 0.5, 2)
+	}
+	for week := 0; week < 2; week++ {
+		for i := 0; i < 20; i++ {
+			_, ok := add(monday.AddDate(0, 0, 7*week).Add(time.Duration(i)*3*time.Minute), 300)
+			assert.False(t, ok, "")
+		}
+		add(monday.AddDate(0, 0, 7*week).Add(time.Hour), 100)
+	}
+
+	d, ok := add(monday.AddDate(0, 0, 14), 150)
+	assert.True(t, ok, "")
+	assert.Equal(t, "Mon 09:00", d.HourOfWeek, "")
+	assert.Equal(t, 300.0, d.Baseline, "")
+	assert.Equal(t, -0.5, d.Deviation, "")
+}
+
+func TestBaselineGauges(t *testing.T) {
+	ss := cmSamples("1")
+	assert.Equal(t, []string{"cm_onphone", "1", "", "10000", "103.25.23.75", "8000", ""}, nodeLabelValues(&ss[0]), "")
+
+	saved, savedOutput, savedStore := globeCfg.Baseline, globeCfg.Output, baselines
+	defer func() { globeCfg.Baseline, globeCfg.Output, baselines = saved, savedOutput, savedStore }()
+	globeCfg.Output.Prometheus = true
+	globeCfg.Baseline.Enable = true
+	globeCfg.Baseline.Path = filepath.Join(t.TempDir(), "baseline.gob")
+	globeCfg.Baseline.Flush = 900
+	openBaseline()
+
+	// 消失的序列在下个周期删除
+	baseline_value.WithLabelValues(nodeLabelValues(&ss[0])...).Set(1)
+	updateBaseline(nil)
+	assert.Equal(t, 0, collectCount(baseline_value), "")
+
+	// 未到flush间隔不落盘
+	_, err := os.Stat(globeCfg.Baseline.Path)
+	assert.True(t, os.IsNotExist(err), "")
+}
@@ -0,0 +1,316 @@
+// capacity
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var ( //capacity
+	capacity_utilization = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "capacity",
+			Name:      "utilization",
+			Help:      "online user / fixed user of host.",
+		},
+		[]string{
+			"HostID",
+			"IP",
+			"Port",
+		},
+	)
+	capacity_clusterUtilization = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "capacity",
+			Name:      "cluster_utilization",
+			Help:      "online user / fixed user of all hosts.",
+		},
+	)
+	capacity_daysLeft = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "capacity",
+			Name:      "days_to_threshold",
+			Help:      "forecast days until utilization exceeds threshold, -1 if not growing.",
+		},
+		[]string{
+			"HostID",
+		},
+	)
+)
+
+func regCapacity() {
+	prometheus.MustRegister(capacity_utilization)
+	prometheus.MustRegister(capacity_clusterUtilization)
+	prometheus.MustRegister(capacity_daysLeft)
+}
+
+type capacityForecast struct {
+	HostID      string     `json:"hostId"` // cluster为整个集群
+	IP          string     `json:"ip,omitempty"`
+	Port        string     `json:"port,omitempty"`
+	FixedUser   float64    `json:"fixedUser"`
+	OnlineUser  float64    `json:"onlineUser"`
+	Utilization float64    `json:"utilization"`
+	PeakDays    int        `json:"peakDays"`           // 参与拟合的天数
+	SlopePerDay float64    `json:"slopePerDay"`        // 峰值利用率每天的增长
+	DaysLeft    float64    `json:"daysLeft"`           // -1 表示未增长或数据不足
+	ETA         *time.Time `json:"eta,omitempty"`      // 预计超过阈值的日期
+	Exceeded    bool       `json:"exceeded,omitempty"` // 当前已超过阈值
+}
+
+type capacityReport struct {
+	Threshold float64             `json:"threshold"`
+	Method    string              `json:"method"`
+	Time      time.Time           `json:"time"`
+	Cluster   *capacityForecast   `json:"cluster"`
+	Hosts     []*capacityForecast `json:"hosts"`
+}
+
+// linearFit 最小二乘拟合 y = slope*x + intercept
+func linearFit(xs, ys []float64) (slope, intercept float64, ok bool) {
+	n := float64(len(xs))
+	if len(xs) < 2 {
+		return 0, 0, false
+	}
+	var sx, sy, sxx, sxy float64
+	for i := range xs {
+		sx += xs[i]
+		sy += ys[i]
+		sxx += xs[i] * xs[i]
+		sxy += xs[i] * ys[i]
+	}
+	d := n*sxx - sx*sx
+	if d == 0 {
+		return 0, 0, false
+	}
+	slope = (n*sxy - sx*sy) / d
+	intercept = (sy - slope*sx) / n
+	return slope, intercept, true
+}
+
+// weeklyPeaks seasonal方式下按7天取峰值, 消除周内的波动
+func weeklyPeaks(days [][2]float64) [][2]float64 {
+	var ws [][2]float64
+	for i := 0; i < len(days); i += 7 {
+		end := i + 7
+		if end > len(days) {
+			end = len(days)
+		}
+		peak := days[i]
+		for _, d := range days[i:end] {
+			if d[1] > peak[1] {
+				peak = d
+			}
+		}
+		ws = append(ws, peak)
+	}
+	return ws
+}
+
+// forecast 对每日峰值利用率([天序号, 利用率])拟合, 返回距今超过threshold的天数
+func forecast(days [][2]float64, threshold float64, method string) (slope, daysLeft float64) {
+	pts := days
+	if method == "seasonal" {
+		pts = weeklyPeaks(days)
+	}
+	xs := make([]float64, len(pts))
+	ys := make([]float64, len(pts))
+	for i, p := range pts {
+		xs[i], ys[i] = p[0], p[1]
+	}
+	slope, intercept, ok := linearFit(xs, ys)
+	if !ok || slope <= 0 {
+		return slope, -1
+	}
+	// x已是距今天的天数, 今天的桶缺失时也不用再减最后一个点
+	daysLeft = (threshold - intercept) / slope
+	if daysLeft < 0 {
+		daysLeft = 0
+	}
+	return slope, daysLeft
+}
+
+// dailyPeakUtilization 由rollup的日峰值计算每天的峰值利用率, x为距today的天数(负数)
+func dailyPeakUtilization(onlineMetric string, match []label, fixed func(day int64) float64, from, today time.Time) [][2]float64 {
+	var days [][2]float64
+	if rollups == nil {
+		return days
+	}
+	for _, r := range rollups.query(onlineMetric, match, periodDay, from.Unix(), today.Unix()) {
+		for _, b := range r.Buckets {
+			f := fixed(b.Start.Unix())
+			if f <= 0 {
+				continue
+			}
+			x := math.Round(b.Start.Sub(today).Hours() / 24)
+			days = append(days, [2]float64{x, b.Max / f})
+		}
+	}
+	return days
+}
+
+func capacityParams() (threshold float64, method string, history int) {
+	threshold = globeCfg.Capacity.Threshold
+	if threshold <= 0 {
+		threshold = 0.8
+	}
+	method = globeCfg.Capacity.Method
+	if method != "seasonal" {
+		method = "linear"
+	}
+	history = globeCfg.Capacity.History
+	if history <= 0 {
+		history = 60
+	}
+	return
+}
+
+func (cf *capacityForecast) predict(days [][2]float64, threshold float64, method string, today time.Time) {
+	cf.PeakDays = len(days)
+	cf.SlopePerDay, cf.DaysLeft = forecast(days, threshold, method)
+	cf.Exceeded = cf.Utilization >= threshold
+	if cf.DaysLeft >= 0 {
+		eta := today.Add(time.Duration(cf.DaysLeft*24) * time.Hour)
+		cf.ETA = &eta
+	}
+}
+
+func buildCapacity(now time.Time) *capacityReport {
+	threshold, method, history := capacityParams()
+	today := time.Unix(periodStart(now, periodDay), 0)
+	from := today.AddDate(0, 0, -history)
+	cr := &capacityReport{Threshold: threshold, Method: method, Time: now, Hosts: []*capacityForecast{}}
+	cluster := &capacityForecast{HostID: "cluster"}
+
+	//  时间 Host节点ID Host IP Host Port Host是否健康 *额定用户数 *在线用户数
+	for _, rec := range lastCycle.records("host") {
+		fixed, _ := strconv.ParseFloat(rec[5], 64)
+		online, _ := strconv.ParseFloat(rec[6], 64)
+		cf := &capacityForecast{HostID: rec[1], IP: rec[2], Port: rec[3], FixedUser: fixed, OnlineUser: online}
+		if fixed > 0 {
+			cf.Utilization = online / fixed
+		}
+		match := []label{{Name: "HostID", Value: rec[1]}}
+		fixedByDay := make(map[int64]float64)
+		if rollups != nil {
+			for _, r := range rollups.query("host_fixedUser", match, periodDay, from.Unix(), today.Unix()) {
+				for _, b := range r.Buckets {
+					fixedByDay[b.Start.Unix()] = b.Max
+				}
+			}
+		}
+		days := dailyPeakUtilization("host_onlineUser", match, func(day int64) float64 {
+			if f, ok := fixedByDay[day]; ok {
+				return f
+			}
+			return fixed
+		}, from, today)
+		cf.predict(days, threshold, method, today)
+		cr.Hosts = append(cr.Hosts, cf)
+
+		cluster.FixedUser += fixed
+		cluster.OnlineUser += online
+	}
+	if cluster.FixedUser > 0 {
+		cluster.Utilization = cluster.OnlineUser / cluster.FixedUser
+	}
+	days := dailyPeakUtilization("userStatistic_online", nil, func(int64) float64 { return cluster.FixedUser }, from, today)
+	cluster.predict(days, threshold, method, today)
+	cr.Cluster = cluster
+	return cr
+}
+
+var lastCapacity struct {
+	sync.Mutex
+	weekReported string
+}
+
+func updateCapacity() {
+	cr := buildCapacity(time.Now())
+	if globeCfg.Output.Prometheus || globeCfg.Output.PushGateway {
+		// Host下线后不再出现, 每个周期重新设置
+		capacity_utilization.Reset()
+		capacity_daysLeft.Reset()
+		capacity_clusterUtilization.Set(cr.Cluster.Utilization)
+		capacity_daysLeft.WithLabelValues(cr.Cluster.HostID).Set(cr.Cluster.DaysLeft)
+		for _, cf := range cr.Hosts {
+			capacity_utilization.WithLabelValues(cf.HostID, cf.IP, cf.Port).Set(cf.Utilization)
+			capacity_daysLeft.WithLabelValues(cf.HostID).Set(cf.DaysLeft)
+		}
+	}
+	writeWeeklyCapacity(cr)
+}
+
+// writeWeeklyCapacity 每周生成一次 report/capacity-2017-W27.csv
+func writeWeeklyCapacity(cr *capacityReport) {
+	if globeCfg.Report.Dir == "" {
+		return
+	}
+	year, week := cr.Time.ISOWeek()
+	name := fmt.Sprintf("capacity-%d-W%02d.csv", year, week)
+	lastCapacity.Lock()
+	defer lastCapacity.Unlock()
+	if lastCapacity.weekReported == name {
+		return
+	}
+	file := filepath.Join(globeCfg.Report.Dir, name)
+	if _, err := os.Stat(file); err == nil {
+		lastCapacity.weekReported = name
+		return
+	}
+	if err := os.MkdirAll(globeCfg.Report.Dir, 0755); err != nil {
+		log.Println("writeWeeklyCapacity:", err)
+		return
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		log.Println("writeWeeklyCapacity:", err)
+		return
+	}
+	writeCapacityCSV(f, cr)
+	if err := f.Close(); err != nil {
+		log.Println("writeWeeklyCapacity:", err)
+		return
+	}
+	lastCapacity.weekReported = name
+}
+
+func writeCapacityCSV(w io.Writer, cr *capacityReport) {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"hostId", "ip", "port", "fixedUser", "onlineUser", "utilization", "peakDays", "slopePerDay", "daysLeft", "eta"})
+	ff := func(v float64) string { return strconv.FormatFloat(v, 'f', 4, 64) }
+	for _, cf := range append(cr.Hosts, cr.Cluster) {
+		eta := ""
+		if cf.ETA != nil {
+			eta = cf.ETA.Format("2006-01-02")
+		}
+		cw.Write([]string{cf.HostID, cf.IP, cf.Port, ff(cf.FixedUser), ff(cf.OnlineUser), ff(cf.Utilization),
+			strconv.Itoa(cf.PeakDays), ff(cf.SlopePerDay), ff(cf.DaysLeft), eta})
+	}
+	cw.Flush()
+}
+
+// /api/v1/capacity, ?format=csv
+func capacityHandler(w http.ResponseWriter, r *http.Request) {
+	cr := buildCapacity(time.Now())
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		writeCapacityCSV(w, cr)
+		return
+	}
+	writeJSON(w, http.StatusOK, cr)
+}
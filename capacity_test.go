@@ -0,0 +1,76 @@
+// capacity_test
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stvp/assert"
+)
+
+func TestForecast(t *testing.T) {
+	var days [][2]float64
+	for x := -13.0; x <= 0; x++ {
+		days = append(days, [2]float64{x, 0.7 + 0.01*x})
+	}
+	slope, left := forecast(days, 0.8, "linear")
+	assert.True(t, slope > 0.0099 && slope < 0.0101, "")
+	assert.True(t, left > 9.99 && left < 10.01, "")
+
+	_, left = forecast(days, 0.8, "seasonal")
+	assert.True(t, left > 9.99 && left < 10.01, "")
+
+	_, left = forecast(days, 0.5, "linear")
+	assert.Equal(t, 0.0, left, "")
+
+	// 今天和前两天的桶缺失
+	_, left = forecast(days[:11], 0.8, "linear")
+	assert.True(t, left > 9.99 && left < 10.01, "")
+	_, left = forecast(days[:11], 0.8, "seasonal")
+	assert.True(t, left > 9.99 && left < 10.01, "")
+
+	flat := [][2]float64{{-2, 0.5}, {-1, 0.5}, {0, 0.5}}
+	_, left = forecast(flat, 0.8, "linear")
+	assert.Equal(t, -1.0, left, "")
+}
+
+func TestBuildCapacity(t *testing.T) {
+	rollups = &rollupStore{Series: make(map[string]*rollupSeries)}
+	defer func() { rollups = nil }()
+
+	now := time.Now()
+	today := time.Unix(periodStart(now, periodDay), 0)
+	ls := []label{{"HostID", "10000"}, {"IP", "103.25.23.75"}, {"Port", "11015"}}
+	for d := 10; d >= 0; d-- {
+		ts := today.AddDate(0, 0, -d).Add(12 * time.Hour)
+		rollups.add(sample{Action: "host", Field: "fixedUser", Labels: ls, Value: 1000, Time: ts}, 24*time.Hour, 90*24*time.Hour)
+		rollups.add(sample{Action: "host", Field: "onlineUser", Labels: ls, Value: float64(500 + 20*(10-d)), Time: ts}, 24*time.Hour, 90*24*time.Hour)
+	}
+	commitRecords("host",
+		"2017.07.04 14:45:41.639|10000|103.25.23.75|11015|1|1000|700",
+		"2017.07.04 14:45:41.639|10001|175.102.132.81|11015|1|1000|100")
+
+	cr := buildCapacity(now)
+	assert.Equal(t, 2, len(cr.Hosts), "")
+	h := cr.Hosts[0]
+	assert.Equal(t, "10000", h.HostID, "")
+	assert.Equal(t, 0.7, h.Utilization, "")
+	assert.Equal(t, 11, h.PeakDays, "")
+	assert.True(t, h.DaysLeft > 4.99 && h.DaysLeft < 5.01, "")
+	assert.NotNil(t, h.ETA, "")
+	assert.Equal(t, -1.0, cr.Hosts[1].DaysLeft, "")
+	assert.Equal(t, 0.4, cr.Cluster.Utilization, "")
+
+	// 下线的Host不再导出
+	saved, savedReport := globeCfg.Output, globeCfg.Report
+	defer func() { globeCfg.Output, globeCfg.Report = saved, savedReport }()
+	globeCfg.Output.Prometheus = true
+	globeCfg.Report.Dir = ""
+	updateCapacity()
+	assert.Equal(t, 2, collectCount(capacity_utilization), "")
+	assert.Equal(t, 3, collectCount(capacity_daysLeft), "")
+	commitRecords("host", "2017.07.04 14:48:41.639|10000|103.25.23.75|11015|1|1000|700")
+	updateCapacity()
+	assert.Equal(t, 1, collectCount(capacity_utilization), "")
+	assert.Equal(t, 2, collectCount(capacity_daysLeft), "")
+}
@@ -0,0 +1,137 @@
+// cmd
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// isCommand 第一个参数不是flag时为子命令, 此时不加载门户配置, 也不注册指标
+func isCommand(args []string) bool {
+	return len(args) > 1 && !strings.HasPrefix(args[1], "-")
+}
+
+// runCommand 处理命令行子命令, 子命令从正在运行的门户的/api/v1接口取数据
+func runCommand(args []string) int {
+	switch args[0] {
+	case "topology":
+		return cmdTopology(args[1:])
+	case "report":
+		return cmdReport(args[1:])
+	case "relay":
+		return cmdRelay(args[1:])
+	}
+	fmt.Fprintln(os.Stderr, "unknown command:", args[0])
+	fmt.Fprintln(os.Stderr, "usage: p2pvdn topology [--dot] [--gw url]")
+	fmt.Fprintln(os.Stderr, "       p2pvdn report capacity|bandwidth|funnel|sla [--from t] [--to t] [--period day|month] [--date 2006-01-02] [--format csv|html] [--gw url]")
+	fmt.Fprintln(os.Stderr, "       p2pvdn relay balance [--json] [--gw url]")
+	return 2
+}
+
+func defaultGwURL() string {
+	return fmt.Sprintf("http://%s:%d", globeCfg.Gw.Addr, globeCfg.Gw.HttpListenPort)
+}
+
+func fetchGw(url string, out io.Writer) error {
+	client := http.Client{Timeout: 10 * time.Second}
+	rsp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return errors.New("http.respose.statuscode:" + strconv.Itoa(rsp.StatusCode))
+	}
+	_, err = io.Copy(out, rsp.Body)
+	return err
+}
+
+func cmdTopology(args []string) int {
+	fs := flag.NewFlagSet("topology", flag.ContinueOnError)
+	dot := fs.Bool("dot", false, "output Graphviz DOT instead of JSON")
+	gw := fs.String("gw", defaultGwURL(), "gateway base url")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	url := *gw + "/api/v1/topology"
+	if *dot {
+		url += "?format=dot"
+	}
+	if err := fetchGw(url, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "topology:", err)
+		return 1
+	}
+	return 0
+}
+
+func cmdReport(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: p2pvdn report capacity|bandwidth|funnel|sla [--from t] [--to t] [--period day|month] [--date 2006-01-02] [--format csv|html] [--gw url]")
+		return 2
+	}
+	fs := flag.NewFlagSet("report "+args[0], flag.ContinueOnError)
+	gw := fs.String("gw", defaultGwURL(), "gateway base url")
+	from := fs.String("from", "", "start time, unix seconds, RFC3339 or 2006-01-02")
+	to := fs.String("to", "", "end time, unix seconds, RFC3339 or 2006-01-02")
+	period := fs.String("period", "", "bandwidth: day or month(billing period)")
+	date := fs.String("date", "", "funnel: day of the report, default the latest window")
+	format := fs.String("format", "csv", "csv or html(funnel, sla)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	q := url.Values{}
+	for k, v := range map[string]string{"from": *from, "to": *to, "period": *period, "date": *date, "format": *format} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	var path string
+	switch args[0] {
+	case "capacity":
+		path = "/api/v1/capacity"
+	case "bandwidth":
+		path = "/api/v1/bandwidth"
+	case "funnel":
+		path = "/api/v1/funnel"
+	case "sla":
+		path = "/api/v1/sla"
+	default:
+		fmt.Fprintln(os.Stderr, "unknown report:", args[0])
+		return 2
+	}
+	if err := fetchGw(*gw+path+"?"+q.Encode(), os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "report:", err)
+		return 1
+	}
+	return 0
+}
+
+func cmdRelay(args []string) int {
+	if len(args) == 0 || args[0] != "balance" {
+		fmt.Fprintln(os.Stderr, "usage: p2pvdn relay balance [--json] [--gw url]")
+		return 2
+	}
+	fs := flag.NewFlagSet("relay balance", flag.ContinueOnError)
+	js := fs.Bool("json", false, "output JSON instead of text")
+	gw := fs.String("gw", defaultGwURL(), "gateway base url")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	url := *gw + "/api/v1/balance"
+	if !*js {
+		url += "?format=text"
+	}
+	if err := fetchGw(url, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "relay balance:", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,28 @@
+// cmd_test
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stvp/assert"
+)
+
+func TestCmdCfg(t *testing.T) {
+	assert.True(t, isCommand([]string{"p2pvdn", "report", "sla"}), "")
+	assert.False(t, isCommand([]string{"p2pvdn"}), "")
+	assert.False(t, isCommand([]string{"p2pvdn.test", "-test.v"}), "")
+
+	// 没有cfg.yaml时子命令用默认地址
+	saved := globeCfg
+	defer func() { globeCfg = saved }()
+	wd, _ := os.Getwd()
+	dir, err := ioutil.TempDir("", "cmd")
+	assert.Nil(t, err, "")
+	defer os.RemoveAll(dir)
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+	loadCmdCfg()
+	assert.Equal(t, "http://localhost:9210", defaultGwURL(), "")
+}
@@ -0,0 +1,151 @@
+// connqueue
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// connQueue 按顺序把消息写到tcp/udp/unix/tls连接, 供socket类的sink使用
+// 连接在写时建立, 断开后退避重连; 未发出的消息最多缓存buffer条, 超出丢弃最旧的
+type connQueue struct {
+	network, addr string
+	buffer        int           // 最多缓存的未发出消息数
+	timeout       time.Duration // 连接和写超时
+	payload       int           // udp/unixgram每个数据报的最大字节数
+	tls           *tls.Config
+	conn          net.Conn
+	pending       []string // 未发出的消息, 行协议含换行符
+	backoff       time.Duration
+	retryAt       time.Time
+	dropped       prometheus.Counter
+	buffered      prometheus.Gauge
+}
+
+func newConnQueue(network, addr string, buffer, timeout, payload int) *connQueue {
+	cq := &connQueue{
+		network: network,
+		addr:    addr,
+		buffer:  buffer,
+		timeout: time.Duration(timeout) * time.Second,
+		payload: payload,
+	}
+	if cq.buffer <= 0 {
+		cq.buffer = 100000
+	}
+	if cq.timeout <= 0 {
+		cq.timeout = 5 * time.Second
+	}
+	if cq.payload <= 0 {
+		cq.payload = 1400
+	}
+	return cq
+}
+
+func (cq *connQueue) datagram() bool {
+	switch cq.network {
+	case "udp", "udp4", "udp6", "unixgram":
+		return true
+	}
+	return false
+}
+
+// packets 每次写出的消息数; 数据报按payload拼包, 单条超长时单独成包
+func (cq *connQueue) packets() []int {
+	if !cq.datagram() {
+		return []int{len(cq.pending)}
+	}
+	var ps []int
+	size, k := 0, 0
+	for _, m := range cq.pending {
+		if k > 0 && size+len(m) > cq.payload {
+			ps = append(ps, k)
+			size, k = 0, 0
+		}
+		size += len(m)
+		k++
+	}
+	if k > 0 {
+		ps = append(ps, k)
+	}
+	return ps
+}
+
+// enqueue 缓存新的消息, 超出上限时丢弃最旧的
+func (cq *connQueue) enqueue(msgs ...string) {
+	cq.pending = append(cq.pending, msgs...)
+	if n := len(cq.pending) - cq.buffer; n > 0 {
+		cq.dropped.Add(float64(n))
+		cq.pending = append([]string{}, cq.pending[n:]...)
+	}
+	cq.buffered.Set(float64(len(cq.pending)))
+}
+
+func (cq *connQueue) connect() error {
+	if cq.conn != nil {
+		return nil
+	}
+	var conn net.Conn
+	var err error
+	if cq.tls != nil {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: cq.timeout}, "tcp", cq.addr, cq.tls)
+	} else {
+		conn, err = net.DialTimeout(cq.network, cq.addr, cq.timeout)
+	}
+	if err != nil {
+		cq.retry()
+		return err
+	}
+	cq.conn, cq.backoff = conn, 0
+	return nil
+}
+
+// retry 退避时间从1秒起翻倍, 最长1分钟
+func (cq *connQueue) retry() {
+	if cq.backoff *= 2; cq.backoff == 0 {
+		cq.backoff = time.Second
+	}
+	if cq.backoff > time.Minute {
+		cq.backoff = time.Minute
+	}
+	cq.retryAt = time.Now().Add(cq.backoff)
+}
+
+// flush 写出缓存的消息, 退避期间不重连
+func (cq *connQueue) flush() error {
+	if len(cq.pending) == 0 || cq.conn == nil && time.Now().Before(cq.retryAt) {
+		return nil
+	}
+	if err := cq.connect(); err != nil {
+		return err
+	}
+
+	// 去掉已完整写出的消息
+	sent := 0
+	var err error
+	for _, k := range cq.packets() {
+		cq.conn.SetWriteDeadline(time.Now().Add(cq.timeout))
+		var n int
+		n, err = io.WriteString(cq.conn, strings.Join(cq.pending[sent:sent+k], ""))
+		for end := sent + k; sent < end && n >= len(cq.pending[sent]); sent++ {
+			n -= len(cq.pending[sent])
+		}
+		if err != nil {
+			break
+		}
+	}
+	cq.pending = append([]string{}, cq.pending[sent:]...)
+	cq.buffered.Set(float64(len(cq.pending)))
+	if err != nil {
+		cq.conn.Close()
+		cq.conn = nil
+		cq.retry()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+// cycle
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// serverSummary 服务器类型
+var svcTypeNames = map[string]string{
+	"1": "dht",
+	"2": "anps",
+	"3": "cm",
+	"4": "host",
+	"5": "bootstrap",
+	"6": "sps",
+	"8": "relay",
+}
+
+func svcTypeName(svcType string) string {
+	if name, ok := svcTypeNames[svcType]; ok {
+		return name
+	}
+	return "type" + svcType
+}
+
+// 记录第一列时间格式 2017.07.04 14:45:41.639
+const recordTimeLayout = "2006.01.02 15:04:05.000"
+
+func recordTimeParse(s string) (time.Time, error) {
+	return time.ParseInLocation(recordTimeLayout, strings.TrimSpace(s), time.Local)
+}
+
+func recordTime(rec []string) (time.Time, bool) {
+	if len(rec) == 0 {
+		return time.Time{}, false
+	}
+	t, err := recordTimeParse(rec[0])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// 一个action在最近一个采集周期解析出的记录
+type actionCycle struct {
+	Action    string
+	Collected time.Time
+	Records   [][]string
+	LastErr   string
+}
+
+func newActionCycle(action string) *actionCycle {
+	return &actionCycle{Action: action}
+}
+
+// extractor 先保存原始字段, 再交给原extractor处理
+func (ac *actionCycle) extractor(next VMDExtractor) VMDExtractor {
+	return func(infos []string) error {
+		rec := make([]string, len(infos))
+		copy(rec, infos)
+		ac.Records = append(ac.Records, rec)
+		return next(infos)
+	}
+}
+
+// oldest 返回记录中最早的时间, 没有可解析的记录时间则用采集时间
+func (ac *actionCycle) oldest() time.Time {
+	var oldest time.Time
+	for _, rec := range ac.Records {
+		if t, ok := recordTime(rec); ok && (oldest.IsZero() || t.Before(oldest)) {
+			oldest = t
+		}
+	}
+	if oldest.IsZero() {
+		return ac.Collected
+	}
+	return oldest
+}
+
+type cycleCache struct {
+	sync.RWMutex
+	actions map[string]*actionCycle
+}
+
+var lastCycle = cycleCache{actions: make(map[string]*actionCycle)}
+
+// commit 采集成功则替换该action的记录, 失败则保留上次的记录并记下错误
+func (cc *cycleCache) commit(ac *actionCycle, err error) {
+	cc.Lock()
+	defer cc.Unlock()
+	if err != nil {
+		failed := actionCycle{Action: ac.Action}
+		if prev, ok := cc.actions[ac.Action]; ok {
+			failed = *prev
+		}
+		failed.LastErr = err.Error()
+		cc.actions[ac.Action] = &failed
+		return
+	}
+	ac.Collected = time.Now()
+	cc.actions[ac.Action] = ac
+}
+
+func (cc *cycleCache) get(action string) *actionCycle {
+	cc.RLock()
+	defer cc.RUnlock()
+	return cc.actions[action]
+}
+
+func (cc *cycleCache) records(action string) [][]string {
+	if ac := cc.get(action); ac != nil {
+		return ac.Records
+	}
+	return nil
+}
+
+func (cc *cycleCache) all() map[string]*actionCycle {
+	cc.RLock()
+	defer cc.RUnlock()
+	m := make(map[string]*actionCycle, len(cc.actions))
+	for k, v := range cc.actions {
+		m[k] = v
+	}
+	return m
+}
+
+// afterCycle 每个采集周期结束后调用
+func afterCycle() {
+	updateImpact()
+	updateBalance()
+	updateBandwidth()
+	updateDhtRing()
+	updatePush()
+	updateFunnel()
+	updateSla()
+
+	ss := cycleSamples()
+	updateTsdb(ss)
+	updateRollup(ss)
+	updateBaseline(ss)
+	updateAnomaly(ss)
+	updateCapacity()
+}
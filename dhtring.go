@@ -0,0 +1,243 @@
+// dhtring
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var ( //dht ring
+	dhtRing_routeDivergence = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "dht_ring",
+			Name:      "route_divergence",
+			Help:      "|route - median route of peers| / median.",
+		},
+		[]string{
+			"DhtId",
+		},
+	)
+	dhtRing_hostListMismatch = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "dht_ring",
+			Name:      "host_list_mismatch",
+			Help:      "1 if the Host list differs from the majority of DHT nodes.",
+		},
+		[]string{
+			"DhtId",
+		},
+	)
+	dhtRing_getSetSkew = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "dht_ring",
+			Name:      "getset_skew",
+			Help:      "GetValue/SetValue ratio of the node relative to the median of the ring.",
+		},
+		[]string{
+			"DhtId",
+		},
+	)
+	dhtRing_consistent = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "dht_ring",
+			Name:      "consistent",
+			Help:      "1 if all DHT nodes see the same Host list.",
+		},
+	)
+)
+
+func regDhtRing() {
+	prometheus.MustRegister(dhtRing_routeDivergence)
+	prometheus.MustRegister(dhtRing_hostListMismatch)
+	prometheus.MustRegister(dhtRing_getSetSkew)
+	prometheus.MustRegister(dhtRing_consistent)
+}
+
+type dhtRingNode struct {
+	DhtID           string   `json:"dhtId"`
+	IP              string   `json:"ip"`
+	Port            string   `json:"port"`
+	Route           int      `json:"route"`
+	RouteDivergence float64  `json:"routeDivergence"`
+	Hosts           []int    `json:"hosts"`
+	MissingHosts    []int    `json:"missingHosts,omitempty"` // 多数DHT能看到而该节点看不到的Host
+	ExtraHosts      []int    `json:"extraHosts,omitempty"`
+	GetValue        float64  `json:"getValue"`
+	SetValue        float64  `json:"setValue"`
+	GetSetSkew      float64  `json:"getSetSkew"`
+	Problems        []string `json:"problems"`
+}
+
+type dhtRingReport struct {
+	Consistent  bool           `json:"consistent"`
+	MedianRoute float64        `json:"medianRoute"`
+	Hosts       []int          `json:"hosts"`       // 多数DHT看到的Host列表
+	GetSetRatio float64        `json:"getSetRatio"` // 各DHT GetValue/SetValue比值的中位数
+	Nodes       []*dhtRingNode `json:"nodes"`
+}
+
+func dhtRingParams() (routeDivergence, getSetSkew float64) {
+	routeDivergence = globeCfg.DhtRing.RouteDivergence
+	if routeDivergence <= 0 {
+		routeDivergence = 0.3
+	}
+	getSetSkew = globeCfg.DhtRing.GetSetSkew
+	if getSetSkew <= 1 {
+		getSetSkew = 3
+	}
+	return
+}
+
+func median(vs []float64) float64 {
+	if len(vs) == 0 {
+		return 0
+	}
+	s := append([]float64{}, vs...)
+	sort.Float64s(s)
+	if n := len(s); n%2 == 0 {
+		return (s[n/2-1] + s[n/2]) / 2
+	}
+	return s[len(s)/2]
+}
+
+func intsKey(ids []int) string {
+	ss := make([]string, len(ids))
+	for i, id := range ids {
+		ss[i] = strconv.Itoa(id)
+	}
+	return strings.Join(ss, ",")
+}
+
+// diffInts a中有b中没有的
+func diffInts(a, b []int) []int {
+	in := make(map[int]bool)
+	for _, id := range b {
+		in[id] = true
+	}
+	var d []int
+	for _, id := range a {
+		if !in[id] {
+			d = append(d, id)
+		}
+	}
+	return d
+}
+
+func buildDhtRing() *dhtRingReport {
+	maxDivergence, maxSkew := dhtRingParams()
+	rr := &dhtRingReport{Consistent: true, Hosts: []int{}, Nodes: []*dhtRingNode{}}
+
+	//  时间 DHT节点id 所属Host节点ID IP Port 连接状态 是否健康 *路由表个数 ... Host列表 *GetValue次数 *SetValue次数
+	var routes, ratios []float64
+	votes := make(map[string]int)
+	lists := make(map[string][]int)
+	for _, rec := range lastCycle.records("dht") {
+		n := &dhtRingNode{DhtID: rec[1], IP: rec[3], Port: rec[4], Hosts: []int{}, Problems: []string{}}
+		n.Route, _ = strconv.Atoi(rec[7])
+		n.GetValue, _ = strconv.ParseFloat(rec[13], 64)
+		n.SetValue, _ = strconv.ParseFloat(rec[14], 64)
+		for _, h := range dhtHostList(rec[12]) {
+			n.Hosts = append(n.Hosts, h.HostID)
+		}
+		sort.Ints(n.Hosts)
+		key := intsKey(n.Hosts)
+		votes[key]++
+		lists[key] = n.Hosts
+
+		routes = append(routes, float64(n.Route))
+		if n.SetValue > 0 {
+			ratios = append(ratios, n.GetValue/n.SetValue)
+		}
+		rr.Nodes = append(rr.Nodes, n)
+	}
+	if len(rr.Nodes) == 0 {
+		return rr
+	}
+
+	// 得票最多的Host列表作为参照, 票数相同时取Host多的
+	best := ""
+	for key, v := range votes {
+		if best == "" || v > votes[best] || v == votes[best] && len(lists[key]) > len(lists[best]) ||
+			v == votes[best] && len(lists[key]) == len(lists[best]) && key < best {
+			best = key
+		}
+	}
+	rr.Hosts = lists[best]
+	rr.Consistent = len(votes) == 1
+	rr.MedianRoute = median(routes)
+	rr.GetSetRatio = median(ratios)
+
+	for _, n := range rr.Nodes {
+		n.RouteDivergence = math.Abs(float64(n.Route)-rr.MedianRoute) / math.Max(rr.MedianRoute, 1)
+		if n.RouteDivergence > maxDivergence {
+			n.Problems = append(n.Problems, "routing table size diverges from peers")
+		}
+		if intsKey(n.Hosts) != best {
+			n.MissingHosts = diffInts(rr.Hosts, n.Hosts)
+			n.ExtraHosts = diffInts(n.Hosts, rr.Hosts)
+			n.Problems = append(n.Problems, "host list differs from peers, possible partition")
+		}
+		if rr.GetSetRatio > 0 && n.SetValue > 0 {
+			n.GetSetSkew = n.GetValue / n.SetValue / rr.GetSetRatio
+			if n.GetSetSkew > maxSkew || n.GetSetSkew < 1/maxSkew {
+				n.Problems = append(n.Problems, "GetValue/SetValue imbalance")
+			}
+		}
+	}
+	return rr
+}
+
+func (rr *dhtRingReport) events() []event {
+	var evs []event
+	for _, n := range rr.Nodes {
+		for _, p := range n.Problems {
+			evs = append(evs, event{
+				Key:      "dht:" + n.DhtID + ":" + p,
+				Severity: "warning",
+				Message:  fmt.Sprintf("dht %s (%s:%s) %s", n.DhtID, n.IP, n.Port, p),
+				Nodes:    []string{topoID("dht", n.DhtID)},
+			})
+		}
+	}
+	return evs
+}
+
+func updateDhtRing() {
+	rr := buildDhtRing()
+	if globeCfg.Output.Prometheus || globeCfg.Output.PushGateway {
+		consistent := 0.0
+		if rr.Consistent {
+			consistent = 1
+		}
+		dhtRing_consistent.Set(consistent)
+		// DHT节点下线后不再出现, 每个周期重新设置
+		dhtRing_routeDivergence.Reset()
+		dhtRing_hostListMismatch.Reset()
+		dhtRing_getSetSkew.Reset()
+		for _, n := range rr.Nodes {
+			mismatch := 0.0
+			if len(n.MissingHosts)+len(n.ExtraHosts) > 0 {
+				mismatch = 1
+			}
+			dhtRing_routeDivergence.WithLabelValues(n.DhtID).Set(n.RouteDivergence)
+			dhtRing_hostListMismatch.WithLabelValues(n.DhtID).Set(mismatch)
+			dhtRing_getSetSkew.WithLabelValues(n.DhtID).Set(n.GetSetSkew)
+		}
+	}
+	events.update("dht-ring", rr.events())
+}
+
+// /api/v1/dht/ring
+func dhtRingHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, buildDhtRing())
+}
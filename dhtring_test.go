@@ -0,0 +1,44 @@
+// dhtring_test
+package main
+
+import (
+	"testing"
+
+	"github.com/stvp/assert"
+)
+
+func TestBuildDhtRing(t *testing.T) {
+	hosts3 := `[{"host_id":10000,"host_pid":14105,"host_ip":"103.25.23.75","host_port":11015},{"host_id":10001,"host_pid":31177,"host_ip":"175.102.132.81","host_port":11015},{"host_id":10002,"host_pid":11766,"host_ip":"121.46.2.18","host_port":10015}]`
+	hosts1 := `[{"host_id":10000,"host_pid":14105,"host_ip":"103.25.23.75","host_port":11015}]`
+	commitRecords("dht",
+		"2017.07.04 14:45:40.973|20001|0|103.25.23.75|10021|1|1|10|4364|325|6421|14|"+hosts3+"|118|5022|115,3,0,0,0,0",
+		"2017.07.04 14:45:40.973|20005|0|175.102.132.81|10021|1|1|10|4364|45|4227|14|"+hosts3+"|315|4873|296,17,0,0,0,0",
+		"2017.07.04 14:45:40.973|20152|0|121.46.2.18|10021|1|1|3|4364|327|6446|14|"+hosts1+"|2680|400|261,7,0,0,0,0",
+	)
+	rr := buildDhtRing()
+	assert.False(t, rr.Consistent, "")
+	assert.Equal(t, 10.0, rr.MedianRoute, "")
+	assert.Equal(t, []int{10000, 10001, 10002}, rr.Hosts, "")
+	assert.Equal(t, 0, len(rr.Nodes[0].Problems), "")
+	assert.Equal(t, 0, len(rr.Nodes[1].Problems), "")
+
+	n := rr.Nodes[2]
+	assert.Equal(t, 0.7, n.RouteDivergence, "")
+	assert.Equal(t, []int{10001, 10002}, n.MissingHosts, "")
+	assert.Equal(t, 3, len(n.Problems), "")
+	assert.Equal(t, 3, len(rr.events()), "")
+
+	// 下线的DHT节点不再导出
+	saved := globeCfg.Output
+	defer func() { globeCfg.Output = saved }()
+	globeCfg.Output.Prometheus = true
+	updateDhtRing()
+	assert.Equal(t, 3, collectCount(dhtRing_hostListMismatch), "")
+	commitRecords("dht",
+		"2017.07.04 14:48:40.973|20001|0|103.25.23.75|10021|1|1|10|4364|325|6421|14|"+hosts3+"|118|5022|115,3,0,0,0,0",
+	)
+	updateDhtRing()
+	assert.Equal(t, 1, collectCount(dhtRing_routeDivergence), "")
+	assert.Equal(t, 1, collectCount(dhtRing_hostListMismatch), "")
+	assert.Equal(t, 1, collectCount(dhtRing_getSetSkew), "")
+}
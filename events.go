@@ -0,0 +1,118 @@
+// events
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	alertFiring   = "firing"
+	alertResolved = "resolved"
+)
+
+type event struct {
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"`
+	Key      string    `json:"key"`
+	Severity string    `json:"severity"`
+	State    string    `json:"state"`
+	Message  string    `json:"message"`
+	Nodes    []string  `json:"nodes,omitempty"`
+}
+
+// 最近的事件, 超出maxEvents丢弃最早的
+const maxEvents = 1000
+
+type eventLog struct {
+	sync.RWMutex
+	events []event
+	active map[string]map[string]event // kind -> key -> 当前告警
+}
+
+var events = eventLog{active: make(map[string]map[string]event)}
+
+func (el *eventLog) emit(ev event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	log.Printf("event %s %s [%s] %s: %s", ev.Kind, ev.State, ev.Severity, ev.Key, ev.Message)
+	el.events = append(el.events, ev)
+	if len(el.events) > maxEvents {
+		el.events = el.events[len(el.events)-maxEvents:]
+	}
+}
+
+// update 用本周期的告警替换kind下的告警, 只在新出现/恢复时产生事件, 持续的告警不重复上报
+func (el *eventLog) update(kind string, firing []event) {
+	el.Lock()
+	defer el.Unlock()
+	prev := el.active[kind]
+	cur := make(map[string]event, len(firing))
+	for _, ev := range firing {
+		ev.Kind = kind
+		ev.State = alertFiring
+		if old, ok := prev[ev.Key]; ok && old.Message == ev.Message {
+			cur[ev.Key] = old
+			continue
+		}
+		el.emit(ev)
+		cur[ev.Key] = el.events[len(el.events)-1]
+	}
+	for key, old := range prev {
+		if _, ok := cur[key]; !ok {
+			old.Time = time.Time{}
+			old.State = alertResolved
+			el.emit(old)
+		}
+	}
+	el.active[kind] = cur
+}
+
+func (el *eventLog) since(kind string, t time.Time) []event {
+	el.RLock()
+	defer el.RUnlock()
+	evs := []event{}
+	for _, ev := range el.events {
+		if (kind == "" || ev.Kind == kind) && !ev.Time.Before(t) {
+			evs = append(evs, ev)
+		}
+	}
+	return evs
+}
+
+func (el *eventLog) alerts() []event {
+	el.RLock()
+	defer el.RUnlock()
+	evs := []event{}
+	for _, m := range el.active {
+		for _, ev := range m {
+			evs = append(evs, ev)
+		}
+	}
+	sort.Slice(evs, func(i, j int) bool { return evs[i].Key < evs[j].Key })
+	return evs
+}
+
+// /api/v1/events?kind=&since=unix秒
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	var since time.Time
+	if s := r.URL.Query().Get("since"); s != "" {
+		sec, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid since", http.StatusBadRequest)
+			return
+		}
+		since = time.Unix(sec, 0)
+	}
+	writeJSON(w, http.StatusOK, events.since(r.URL.Query().Get("kind"), since))
+}
+
+// /api/v1/alerts 当前未恢复的告警
+func alertsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, events.alerts())
+}
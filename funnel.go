@@ -0,0 +1,335 @@
+// funnel
+package main
+
+import (
+	"encoding/csv"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var ( //funnel
+	funnel_calls = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "funnel",
+			Name:      "calls",
+			Help:      "calls at each funnel stage in the window, CmId=cluster for the whole cluster.",
+		},
+		[]string{
+			"CmId",
+			"Stage",
+		},
+	)
+	funnel_blocked = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "funnel",
+			Name:      "blocked",
+			Help:      "blocked calls by reason in the window.",
+		},
+		[]string{
+			"CmId",
+			"Reason",
+		},
+	)
+	funnel_rate = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "funnel",
+			Name:      "rate",
+			Help:      "connect: connected/attempted, complete: completed/attempted, normal: completed/connected.",
+		},
+		[]string{
+			"CmId",
+			"Rate",
+		},
+	)
+)
+
+func regFunnel() {
+	prometheus.MustRegister(funnel_calls)
+	prometheus.MustRegister(funnel_blocked)
+	prometheus.MustRegister(funnel_rate)
+}
+
+// CallMgr未接通原因
+var blockReasons = []struct{ Field, Reason string }{
+	{"blockBySys", "system"},
+	{"blockByOps", "user"},
+	{"blockOffline", "callee-offline"},
+}
+
+// 通话漏斗: 发起 -> 接通 -> 正常结束
+type funnel struct {
+	CmID         string             `json:"cmId"` // cluster为整个集群
+	Attempted    float64            `json:"attempted"`
+	Connected    float64            `json:"connected"`
+	Completed    float64            `json:"completed"`
+	Broken       float64            `json:"broken"`
+	Blocked      map[string]float64 `json:"blocked"`
+	ConnectRate  float64            `json:"connectRate"`
+	CompleteRate float64            `json:"completeRate"`
+	NormalRate   float64            `json:"normalRate"`
+}
+
+func (f *funnel) rates() {
+	if f.Attempted > 0 {
+		f.ConnectRate = f.Connected / f.Attempted
+		f.CompleteRate = f.Completed / f.Attempted
+	}
+	if f.Connected > 0 {
+		f.NormalRate = f.Completed / f.Connected
+	}
+}
+
+// cmFunnel v按cm字段名取值, CallMgr没有通话量字段, 发起数=接通+未接通
+func cmFunnel(cmID string, v func(field string) float64) *funnel {
+	f := &funnel{CmID: cmID, Blocked: make(map[string]float64)}
+	f.Completed = v("hangup")
+	f.Broken = v("broken")
+	f.Connected = f.Completed + f.Broken
+	f.Attempted = f.Connected
+	for _, br := range blockReasons {
+		f.Blocked[br.Reason] = v(br.Field)
+		f.Attempted += f.Blocked[br.Reason]
+	}
+	f.rates()
+	return f
+}
+
+// clusterFunnel v按callStatistic字段名取值, 未接通原因取各CallMgr之和, 差额计为other
+func clusterFunnel(v func(field string) float64, cms []*funnel) *funnel {
+	f := &funnel{CmID: "cluster", Blocked: make(map[string]float64)}
+	f.Attempted = v("callTraffic")
+	blocked := v("blockedCall")
+	f.Connected = f.Attempted - blocked
+	if f.Connected < 0 {
+		f.Connected = 0
+	}
+	f.Completed = v("releasedCall")
+	f.Broken = v("breakedCall")
+	var reasons float64
+	for _, br := range blockReasons {
+		for _, cm := range cms {
+			f.Blocked[br.Reason] += cm.Blocked[br.Reason]
+		}
+		reasons += f.Blocked[br.Reason]
+	}
+	f.Blocked["other"] = 0
+	if blocked > reasons {
+		f.Blocked["other"] = blocked - reasons
+	}
+	f.rates()
+	return f
+}
+
+type funnelReport struct {
+	Time    time.Time `json:"time"` // 窗口的记录时间, 日报为当天0点
+	Cluster *funnel   `json:"cluster"`
+	Cms     []*funnel `json:"cms"`
+}
+
+// buildFunnel 最近一个3分钟窗口
+func buildFunnel() *funnelReport {
+	fr := &funnelReport{Cms: []*funnel{}}
+	cs := schemaOf("cm")
+	for _, rec := range lastCycle.records("cm") {
+		fr.Cms = append(fr.Cms, cmFunnel(rec[1], func(field string) float64 { return cs.num(rec, field) }))
+	}
+	ss := schemaOf("callStatistic")
+	recs := lastCycle.records("callStatistic")
+	if len(recs) > 0 {
+		fr.Time, _ = recordTime(recs[0])
+	}
+	fr.Cluster = clusterFunnel(func(field string) float64 {
+		if len(recs) == 0 {
+			return 0
+		}
+		return ss.num(recs[0], field)
+	}, fr.Cms)
+	return fr
+}
+
+// dailyFunnel 由rollup中当天3分钟窗口值之和计算
+func dailyFunnel(day time.Time) *funnelReport {
+	start := periodStart(day, periodDay)
+	fr := &funnelReport{Time: time.Unix(start, 0), Cms: []*funnel{}}
+	daySum := func(metric string, match []label) float64 {
+		var sum float64
+		for _, r := range rollups.query(metric, match, periodDay, start, start) {
+			for _, b := range r.Buckets {
+				sum += b.Sum
+			}
+		}
+		return sum
+	}
+
+	var cmIDs []string
+	for _, r := range rollups.query("cm_hangup", nil, periodDay, start, start) {
+		if len(r.Buckets) > 0 {
+			cmIDs = append(cmIDs, r.Labels["CmId"])
+		}
+	}
+	sort.Strings(cmIDs)
+	for _, id := range cmIDs {
+		match := []label{{Name: "CmId", Value: id}}
+		fr.Cms = append(fr.Cms, cmFunnel(id, func(field string) float64 { return daySum("cm_"+field, match) }))
+	}
+	fr.Cluster = clusterFunnel(func(field string) float64 { return daySum("callStatistic_"+field, nil) }, fr.Cms)
+	return fr
+}
+
+func writeFunnelCSV(w io.Writer, fr *funnelReport) {
+	cw := csv.NewWriter(w)
+	header := []string{"cmId", "attempted", "connected", "completed", "broken"}
+	for _, br := range blockReasons {
+		header = append(header, "blocked_"+br.Reason)
+	}
+	header = append(header, "blocked_other", "connectRate", "completeRate", "normalRate")
+	cw.Write(header)
+	ff := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	fr4 := func(v float64) string { return strconv.FormatFloat(v, 'f', 4, 64) }
+	for _, f := range append(fr.Cms, fr.Cluster) {
+		row := []string{f.CmID, ff(f.Attempted), ff(f.Connected), ff(f.Completed), ff(f.Broken)}
+		for _, br := range blockReasons {
+			row = append(row, ff(f.Blocked[br.Reason]))
+		}
+		row = append(row, ff(f.Blocked["other"]), fr4(f.ConnectRate), fr4(f.CompleteRate), fr4(f.NormalRate))
+		cw.Write(row)
+	}
+	cw.Flush()
+}
+
+var funnelHTML = template.Must(template.New("funnel").Funcs(template.FuncMap{
+	"num": func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) },
+	"pct": func(v float64) string { return strconv.FormatFloat(v*100, 'f', 1, 64) + "%" },
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>call funnel {{.Time.Format "2006-01-02"}}</title>
+<style>table{border-collapse:collapse}td,th{border:1px solid #999;padding:2px 8px;text-align:right}</style>
+</head><body>
+<h2>call funnel {{.Time.Format "2006-01-02 15:04"}}</h2>
+<table>
+<tr><th>CallMgr</th><th>attempted</th><th>connected</th><th>completed</th><th>broken</th><th>blocked: system</th><th>user</th><th>callee-offline</th><th>other</th><th>connect</th><th>complete</th><th>normal</th></tr>
+{{range .Rows}}<tr><td>{{.CmID}}</td><td>{{num .Attempted}}</td><td>{{num .Connected}}</td><td>{{num .Completed}}</td><td>{{num .Broken}}</td><td>{{num (index .Blocked "system")}}</td><td>{{num (index .Blocked "user")}}</td><td>{{num (index .Blocked "callee-offline")}}</td><td>{{num (index .Blocked "other")}}</td><td>{{pct .ConnectRate}}</td><td>{{pct .CompleteRate}}</td><td>{{pct .NormalRate}}</td></tr>
+{{end}}</table>
+</body></html>
+`))
+
+func writeFunnelHTML(w io.Writer, fr *funnelReport) error {
+	return funnelHTML.Execute(w, struct {
+		Time time.Time
+		Rows []*funnel
+	}{fr.Time, append(fr.Cms, fr.Cluster)})
+}
+
+var lastFunnel struct {
+	sync.Mutex
+	dayReported string
+}
+
+func updateFunnel() {
+	fr := buildFunnel()
+	if globeCfg.Output.Prometheus || globeCfg.Output.PushGateway {
+		// CM下线后不再出现, 每个周期重新设置
+		funnel_calls.Reset()
+		funnel_blocked.Reset()
+		funnel_rate.Reset()
+		for _, f := range append(fr.Cms, fr.Cluster) {
+			funnel_calls.WithLabelValues(f.CmID, "attempted").Set(f.Attempted)
+			funnel_calls.WithLabelValues(f.CmID, "connected").Set(f.Connected)
+			funnel_calls.WithLabelValues(f.CmID, "completed").Set(f.Completed)
+			for reason, n := range f.Blocked {
+				funnel_blocked.WithLabelValues(f.CmID, reason).Set(n)
+			}
+			funnel_rate.WithLabelValues(f.CmID, "connect").Set(f.ConnectRate)
+			funnel_rate.WithLabelValues(f.CmID, "complete").Set(f.CompleteRate)
+			funnel_rate.WithLabelValues(f.CmID, "normal").Set(f.NormalRate)
+		}
+	}
+	writeDailyFunnel(time.Now().AddDate(0, 0, -1))
+}
+
+// writeDailyFunnel 每天生成前一天的 report/funnel-2017-07-04.html 和 .csv
+func writeDailyFunnel(day time.Time) {
+	if globeCfg.Report.Dir == "" || rollups == nil {
+		return
+	}
+	name := "funnel-" + day.Format("2006-01-02")
+	lastFunnel.Lock()
+	defer lastFunnel.Unlock()
+	if lastFunnel.dayReported == name {
+		return
+	}
+	base := filepath.Join(globeCfg.Report.Dir, name)
+	if _, err := os.Stat(base + ".html"); err == nil {
+		lastFunnel.dayReported = name
+		return
+	}
+	if err := os.MkdirAll(globeCfg.Report.Dir, 0755); err != nil {
+		log.Println("writeDailyFunnel:", err)
+		return
+	}
+	fr := dailyFunnel(day)
+	write := func(file string, fn func(io.Writer) error) error {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		if err := fn(f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	err := write(base+".csv", func(w io.Writer) error { writeFunnelCSV(w, fr); return nil })
+	if err == nil {
+		err = write(base+".html", func(w io.Writer) error { return writeFunnelHTML(w, fr) })
+	}
+	if err != nil {
+		log.Println("writeDailyFunnel:", err)
+		return
+	}
+	lastFunnel.dayReported = name
+}
+
+// /api/v1/funnel 最近一个窗口; ?date=2017-07-04 为当天汇总, 需要开启rollup; ?format=csv|html
+func funnelHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var fr *funnelReport
+	if date := q.Get("date"); date != "" {
+		if rollups == nil {
+			http.Error(w, "rollup disabled", http.StatusNotFound)
+			return
+		}
+		day, err := time.ParseInLocation("2006-01-02", date, time.Local)
+		if err != nil {
+			http.Error(w, "invalid date", http.StatusBadRequest)
+			return
+		}
+		fr = dailyFunnel(day)
+	} else {
+		fr = buildFunnel()
+	}
+	switch q.Get("format") {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		writeFunnelCSV(w, fr)
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := writeFunnelHTML(w, fr); err != nil {
+			log.Println("funnelHandler:", err)
+		}
+	default:
+		writeJSON(w, http.StatusOK, fr)
+	}
+}
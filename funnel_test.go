@@ -0,0 +1,78 @@
+// funnel_test
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stvp/assert"
+)
+
+func TestBuildFunnel(t *testing.T) {
+	defer commitRecords("cm")
+	commitRecords("callStatistic", "2017.07.04 14:45:41.639|30|10|20|100|20|70|10")
+	commitRecords("cm",
+		"2017.07.04 14:45:41.639|40001|10000|103.25.23.75|10012|10|0|0|40|5|4|3|3",
+		"2017.07.04 14:45:41.639|40002|10001|175.102.132.81|10012|20|0|0|30|5|2|2|4")
+	fr := buildFunnel()
+
+	assert.Equal(t, 2, len(fr.Cms), "")
+	cm := fr.Cms[0]
+	assert.Equal(t, 55.0, cm.Attempted, "")
+	assert.Equal(t, 45.0, cm.Connected, "")
+	assert.Equal(t, 40.0, cm.Completed, "")
+	assert.Equal(t, 4.0, cm.Blocked["system"], "")
+
+	c := fr.Cluster
+	assert.Equal(t, 80.0, c.Connected, "")
+	assert.Equal(t, 0.7, c.CompleteRate, "")
+	assert.Equal(t, 6.0, c.Blocked["system"], "")
+	assert.Equal(t, 7.0, c.Blocked["callee-offline"], "")
+	assert.Equal(t, 2.0, c.Blocked["other"], "")
+
+	var b bytes.Buffer
+	writeFunnelCSV(&b, fr)
+	assert.Equal(t, 4, strings.Count(b.String(), "\n"), "")
+	b.Reset()
+	assert.Nil(t, writeFunnelHTML(&b, fr), "")
+	assert.True(t, strings.Contains(b.String(), "<td>cluster</td><td>100</td><td>80</td>"), "")
+
+	// 下线的CM不再导出
+	saved := globeCfg.Output
+	defer func() { globeCfg.Output = saved }()
+	globeCfg.Output.Prometheus = true
+	updateFunnel()
+	assert.Equal(t, 9, collectCount(funnel_rate), "")
+	commitRecords("cm", "2017.07.04 14:48:41.639|40001|10000|103.25.23.75|10012|10|0|0|40|5|4|3|3")
+	updateFunnel()
+	assert.Equal(t, 6, collectCount(funnel_rate), "")
+	assert.Equal(t, 6, collectCount(funnel_calls), "")
+}
+
+func TestDailyFunnel(t *testing.T) {
+	rollups = &rollupStore{Series: make(map[string]*rollupSeries)}
+	defer func() { rollups = nil }()
+
+	ls := []label{{"CmId", "40001"}, {"HostId", "10000"}, {"IP", "103.25.23.75"}, {"Port", "10012"}}
+	base := time.Date(2017, 7, 4, 14, 0, 0, 0, time.Local)
+	for i := 0; i < 3; i++ {
+		ts := base.Add(time.Duration(i) * 3 * time.Minute)
+		for _, fv := range []struct {
+			action, field string
+			ls            []label
+			v             float64
+		}{
+			{"cm", "hangup", ls, 10}, {"cm", "broken", ls, 1}, {"cm", "blockBySys", ls, 1},
+			{"callStatistic", "callTraffic", nil, 15}, {"callStatistic", "blockedCall", nil, 2}, {"callStatistic", "releasedCall", nil, 10},
+		} {
+			rollups.add(sample{Action: fv.action, Field: fv.field, Labels: fv.ls, Value: fv.v, Time: ts}, 24*time.Hour, 30*24*time.Hour)
+		}
+	}
+	fr := dailyFunnel(base)
+	assert.Equal(t, 1, len(fr.Cms), "")
+	assert.Equal(t, 36.0, fr.Cms[0].Attempted, "")
+	assert.Equal(t, 45.0, fr.Cluster.Attempted, "")
+	assert.Equal(t, 3.0, fr.Cluster.Blocked["other"], "")
+}
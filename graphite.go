@@ -0,0 +1,203 @@
+// graphite
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var ( //graphite
+	graphite_dropped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "p2p",
+			Subsystem: "ops",
+			Name:      "graphite_dropped_messages_total",
+			Help:      "messages dropped because the graphite buffer is full.",
+		},
+	)
+	graphite_buffered = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "ops",
+			Name:      "graphite_buffered_messages",
+			Help:      "messages waiting to be sent to graphite.",
+		},
+	)
+)
+
+func regGraphite() {
+	prometheus.MustRegister(graphite_dropped)
+	prometheus.MustRegister(graphite_buffered)
+}
+
+const (
+	graphiteTemplate = "p2p.{cluster}.{action}.{id}.{field}"
+	graphitePickled  = 500 // 每个pickle消息的最多数据点数
+)
+
+var (
+	graphitePlaceholder = regexp.MustCompile(`\{(\w+)\}`)
+	graphiteUnsafe      = regexp.MustCompile(`[^A-Za-z0-9_\-]`)
+)
+
+// graphiteField 指标名去掉p2p_和action前缀, 如host_onlineUser -> online_user
+func graphiteField(s *sample) string {
+	name := strings.TrimPrefix(promName(s.metric()), "p2p_")
+	if strings.HasPrefix(name, s.Action+"_") {
+		return name[len(s.Action)+1:]
+	}
+	return strings.TrimPrefix(name, strings.ToLower(s.Action)+"_")
+}
+
+// graphitePath 按模板生成路径, 占位符为cluster action field, sample的标签名(不分大小写)
+// 以及Telegraf的tag名(id hostId addr等); 值里的.等字符换成_, 为空的段去掉
+// 没有id tag的(serverSummary等)id取schema的第一个标签, 有SvcType时前面加类型名, 如relay.5
+func graphitePath(tmpl, cluster string, s *sample) string {
+	vars := map[string]string{
+		"cluster": cluster,
+		"action":  s.Action,
+		"field":   graphiteField(s),
+	}
+	for _, l := range telegrafTagSet(s) {
+		vars[strings.ToLower(l.Name)] = l.Value
+	}
+	for _, l := range s.Labels {
+		vars[strings.ToLower(l.Name)] = l.Value
+	}
+	for k, v := range vars {
+		vars[k] = graphiteUnsafe.ReplaceAllString(v, "_")
+	}
+	if _, ok := vars["id"]; !ok {
+		vars["id"] = graphiteUnsafe.ReplaceAllString(nodeLabelValues(s)[1], "_")
+		if t := s.label("SvcType"); t != "" && vars["id"] != "" {
+			vars["id"] = graphiteUnsafe.ReplaceAllString(svcTypeName(t), "_") + "." + vars["id"]
+		}
+	}
+
+	var segs []string
+	for _, seg := range strings.Split(tmpl, ".") {
+		seg = graphitePlaceholder.ReplaceAllStringFunc(seg, func(m string) string {
+			return vars[strings.ToLower(m[1:len(m)-1])]
+		})
+		if seg != "" {
+			segs = append(segs, seg)
+		}
+	}
+	return strings.Join(segs, ".")
+}
+
+type graphitePoint struct {
+	path  string
+	value float64
+	time  int64 // 秒
+}
+
+// graphitePlain 明文协议, 每行 path value timestamp
+func graphitePlain(p graphitePoint) string {
+	return p.path + " " + strconv.FormatFloat(p.value, 'f', -1, 64) + " " + strconv.FormatInt(p.time, 10) + "\n"
+}
+
+// graphitePickle pickle协议(protocol 2), 4字节大端长度加 [(path, (timestamp, value)), ...]
+func graphitePickle(ps []graphitePoint) string {
+	var b bytes.Buffer
+	var buf [8]byte
+	b.WriteString("\x80\x02]") // PROTO 2, EMPTY_LIST
+	b.WriteByte('(')           // MARK
+	for _, p := range ps {
+		b.WriteByte('X') // BINUNICODE
+		binary.LittleEndian.PutUint32(buf[:4], uint32(len(p.path)))
+		b.Write(buf[:4])
+		b.WriteString(p.path)
+		for _, v := range []float64{float64(p.time), p.value} {
+			b.WriteByte('G') // BINFLOAT
+			binary.BigEndian.PutUint64(buf[:], math.Float64bits(v))
+			b.Write(buf[:])
+		}
+		b.WriteString("\x86\x86") // TUPLE2 TUPLE2
+	}
+	b.WriteString("e.") // APPENDS STOP
+
+	binary.BigEndian.PutUint32(buf[:4], uint32(b.Len()))
+	return string(buf[:4]) + b.String()
+}
+
+// graphiteSink 按路径模板写carbon, 明文(2003)或pickle(2004), 时间戳取记录时间
+type graphiteSink struct {
+	*connQueue
+	pickle    bool
+	cluster   string
+	template  string
+	templates map[string]string // action -> 模板
+}
+
+func newGraphiteSink() (*graphiteSink, error) {
+	cfg := &globeCfg.Graphite
+	if cfg.Addr == "" {
+		return nil, errors.New("graphite: addr required")
+	}
+	gs := &graphiteSink{
+		connQueue: newConnQueue("tcp", cfg.Addr, cfg.Buffer, cfg.Timeout, 0),
+		cluster:   cfg.Cluster,
+		template:  cfg.Template,
+		templates: cfg.Templates,
+	}
+	gs.dropped, gs.buffered = graphite_dropped, graphite_buffered
+	switch cfg.Protocol {
+	case "", "plaintext":
+	case "pickle":
+		gs.pickle = true
+	default:
+		return nil, errors.New("graphite: unsupported protocol " + cfg.Protocol)
+	}
+	if gs.template == "" {
+		gs.template = graphiteTemplate
+	}
+	return gs, nil
+}
+
+func (gs *graphiteSink) Name() string { return "graphite" }
+
+// points 只输出scalar的sample
+func (gs *graphiteSink) points(ss []sample) []graphitePoint {
+	var ps []graphitePoint
+	for i := range ss {
+		s := &ss[i]
+		if !s.scalar() {
+			continue
+		}
+		tmpl, ok := gs.templates[s.Action]
+		if !ok {
+			tmpl = gs.template
+		}
+		ps = append(ps, graphitePoint{path: graphitePath(tmpl, gs.cluster, s), value: s.Value, time: s.Time.Unix()})
+	}
+	return ps
+}
+
+func (gs *graphiteSink) Write(ss []sample) error {
+	ps := gs.points(ss)
+	var msgs []string
+	if gs.pickle {
+		for len(ps) > 0 {
+			n := len(ps)
+			if n > graphitePickled {
+				n = graphitePickled
+			}
+			msgs = append(msgs, graphitePickle(ps[:n]))
+			ps = ps[n:]
+		}
+	} else {
+		for _, p := range ps {
+			msgs = append(msgs, graphitePlain(p))
+		}
+	}
+	gs.enqueue(msgs...)
+	return gs.flush()
+}
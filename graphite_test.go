@@ -0,0 +1,84 @@
+// graphite_test
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stvp/assert"
+)
+
+func TestGraphitePath(t *testing.T) {
+	ss := cmSamples("1")
+	s := &ss[0] // cm onphone
+	assert.Equal(t, "p2p.bj.cm.1.onphone", graphitePath(graphiteTemplate, "bj", s), "")
+	// cluster为空时去掉该段, 标签名不分大小写, IP里的.换成_
+	assert.Equal(t, "p2p.cm.10000.103_25_23_75.onphone", graphitePath("p2p.{cluster}.cm.{hostId}.{ip}.{field}", "", s), "")
+	assert.Equal(t, "p2p.cm.103_25_23_75_8000", graphitePath("p2p.cm.{addr}", "", s), "")
+
+	// host的HostID在Telegraf里是id, 两个名字都可以用
+	rec := strings.Split("2017.07.04 14:45:41.639|5|10.0.0.5|80|1|1000|300|20", "|")
+	found := false
+	for _, h := range recordSamples(schemaOf("host"), rec, time.Now()) {
+		if h.Field == "onlineUser" {
+			found = true
+			assert.Equal(t, "p2p.bj.host.5.online_user", graphitePath("p2p.{cluster}.host.{hostId}.{field}", "bj", &h), "")
+			assert.Equal(t, "p2p.bj.host.5.online_user", graphitePath(graphiteTemplate, "bj", &h), "")
+		}
+	}
+	assert.True(t, found, "")
+
+	// serverSummary没有id tag, 用类型名加NodeID, 不同类型的同号节点不会重名
+	var paths []string
+	for _, rec := range []string{
+		"2017.07.04 14:45:41.639|5|8|10.0.0.5|80||1|1",
+		"2017.07.04 14:45:41.639|5|3|10.0.0.6|80|1|1|0",
+	} {
+		for _, h := range recordSamples(schemaOf("serverSummary"), strings.Split(rec, "|"), time.Now()) {
+			if h.Field == "healthy" {
+				paths = append(paths, graphitePath(graphiteTemplate, "bj", &h))
+			}
+		}
+	}
+	assert.Equal(t, []string{"p2p.bj.serverSummary.relay.5.healthy", "p2p.bj.serverSummary.cm.5.healthy"}, paths, "")
+}
+
+func TestGraphitePickle(t *testing.T) {
+	msg := graphitePickle([]graphitePoint{{path: "a.b", value: 1.5, time: 100}})
+	assert.Equal(t, uint32(len(msg)-4), binary.BigEndian.Uint32([]byte(msg[:4])), "")
+	body := msg[4:]
+	assert.Equal(t, "\x80\x02](X\x03\x00\x00\x00a.bG", body[:13], "")
+	assert.Equal(t, "\x86\x86e.", body[len(body)-4:], "")
+	assert.Equal(t, 13+9+8+4, len(body), "")
+}
+
+func TestGraphiteTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err, "")
+	defer ln.Close()
+
+	saved := globeCfg.Graphite
+	defer func() { globeCfg.Graphite = saved }()
+	globeCfg.Graphite.Addr = ln.Addr().String()
+	globeCfg.Graphite.Protocol = "plaintext"
+	globeCfg.Graphite.Template = ""
+	globeCfg.Graphite.Templates = map[string]string{"cm": "p2p.{cluster}.callmgr.{cmId}.{field}"}
+	globeCfg.Graphite.Cluster = "bj"
+	gs, err := newGraphiteSink()
+	assert.Nil(t, err, "")
+
+	ss := cmSamples("1")
+	assert.Nil(t, gs.Write(ss), "")
+	conn, err := ln.Accept()
+	assert.Nil(t, err, "")
+	defer conn.Close()
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	assert.Nil(t, err, "")
+	assert.Equal(t, "p2p.bj.callmgr.1.onphone 10 "+strconv.FormatInt(ss[0].Time.Unix(), 10)+"\n", line, "")
+	assert.Equal(t, 0, len(gs.pending), "")
+}
@@ -0,0 +1,137 @@
+// health
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	healthOk       = "ok"
+	healthDegraded = "degraded"
+	healthCritical = "critical"
+)
+
+type svcHealth struct {
+	Healthy     int `json:"healthy"`
+	Unhealthy   int `json:"unhealthy"`
+	Unpublished int `json:"unpublished"`
+}
+
+type healthSummary struct {
+	Status   string                `json:"status"`
+	Reasons  []string              `json:"reasons,omitempty"`
+	Services map[string]*svcHealth `json:"services"`
+	DataAge  map[string]float64    `json:"dataAge"` // 各action最早一条记录距今的秒数
+	Errors   map[string]string     `json:"errors,omitempty"`
+	Time     time.Time             `json:"time"`
+}
+
+var healthRank = map[string]int{healthOk: 0, healthDegraded: 1, healthCritical: 2}
+
+func (hs *healthSummary) raise(status, reason string) {
+	if healthRank[status] > healthRank[hs.Status] {
+		hs.Status = status
+	}
+	hs.Reasons = append(hs.Reasons, reason)
+}
+
+func healthRules() (degradedUnhealthy int, criticalRatio float64, maxDataAge time.Duration) {
+	degradedUnhealthy = globeCfg.Health.DegradedUnhealthy
+	if degradedUnhealthy <= 0 {
+		degradedUnhealthy = 1
+	}
+	criticalRatio = globeCfg.Health.CriticalRatio
+	if criticalRatio <= 0 {
+		criticalRatio = 0.5
+	}
+	maxDataAge = time.Duration(globeCfg.Health.MaxDataAge) * time.Second
+	if maxDataAge <= 0 {
+		maxDataAge = 3 * time.Duration(globeCfg.Rest.Period) * time.Second
+	}
+	return
+}
+
+// buildHealth 根据最近一个周期的serverSummary及各action的数据时间汇总集群状态
+func buildHealth(now time.Time) *healthSummary {
+	hs := &healthSummary{
+		Status:   healthOk,
+		Services: make(map[string]*svcHealth),
+		DataAge:  make(map[string]float64),
+		Errors:   make(map[string]string),
+		Time:     now,
+	}
+
+	//时间 节点ID 服务器类型 IP port 所属hostID *是否发布 *是否健康
+	for _, rec := range lastCycle.records("serverSummary") {
+		name := svcTypeName(rec[2])
+		sh, ok := hs.Services[name]
+		if !ok {
+			sh = &svcHealth{}
+			hs.Services[name] = sh
+		}
+		published, _ := strconv.Atoi(rec[6])
+		healthy, _ := strconv.Atoi(rec[7])
+		switch {
+		case published == 0:
+			sh.Unpublished++
+		case healthy == 1:
+			sh.Healthy++
+		default:
+			sh.Unhealthy++
+		}
+	}
+
+	degradedUnhealthy, criticalRatio, maxDataAge := healthRules()
+	if len(hs.Services) == 0 {
+		hs.raise(healthCritical, "no serverSummary data")
+	}
+	names := make([]string, 0, len(hs.Services))
+	for name := range hs.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sh := hs.Services[name]
+		total := sh.Healthy + sh.Unhealthy
+		if total == 0 {
+			continue
+		}
+		ratio := float64(sh.Healthy) / float64(total)
+		if ratio < criticalRatio {
+			hs.raise(healthCritical, fmt.Sprintf("%s: %d/%d healthy", name, sh.Healthy, total))
+		} else if sh.Unhealthy >= degradedUnhealthy {
+			hs.raise(healthDegraded, fmt.Sprintf("%s: %d unhealthy", name, sh.Unhealthy))
+		}
+	}
+
+	for action, ac := range lastCycle.all() {
+		if ac.LastErr != "" {
+			hs.Errors[action] = ac.LastErr
+		}
+		if ac.Collected.IsZero() {
+			hs.raise(healthDegraded, action+": never collected")
+			continue
+		}
+		age := now.Sub(ac.oldest())
+		hs.DataAge[action] = age.Seconds()
+		if age > maxDataAge {
+			hs.raise(healthDegraded, fmt.Sprintf("%s: data is %.0fs old", action, age.Seconds()))
+		}
+	}
+	sort.Strings(hs.Reasons)
+	return hs
+}
+
+// /api/v1/health, critical时返回503供负载均衡探测
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	hs := buildHealth(time.Now())
+	status := http.StatusOK
+	if hs.Status == healthCritical {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, hs)
+}
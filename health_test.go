@@ -0,0 +1,46 @@
+// health_test
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stvp/assert"
+)
+
+func commitRecords(action string, lines ...string) {
+	ac := newActionCycle(action)
+	for _, l := range lines {
+		ac.Records = append(ac.Records, strings.Split(l, "|"))
+	}
+	lastCycle.commit(ac, nil)
+}
+
+func TestBuildHealth(t *testing.T) {
+	commitRecords("serverSummary",
+		"2017.07.04 14:45:41.639|10000|4|103.25.23.75|11015|10000|1|1",
+		"2017.07.04 14:45:41.639|10001|4|175.102.132.81|11015|10001|1|0",
+		"2017.07.04 14:45:41.639|10002|4|121.46.2.18|10015|10002|1|1",
+		"2017.07.04 14:45:41.639|20001|1|103.25.23.75|10021|0|1|0",
+		"2017.07.04 14:45:41.639|20005|1|175.102.132.81|10021|0|1|0",
+		"2017.07.04 14:45:41.639|21|8|223.111.205.86|9000|0|0|0",
+	)
+	now, _ := time.ParseInLocation(recordTimeLayout, "2017.07.04 14:46:41.639", time.Local)
+
+	hs := buildHealth(now)
+	t.Log(hs.Status, hs.Reasons)
+	assert.Equal(t, 2, hs.Services["host"].Healthy, "")
+	assert.Equal(t, 1, hs.Services["host"].Unhealthy, "")
+	assert.Equal(t, 2, hs.Services["dht"].Unhealthy, "")
+	assert.Equal(t, 1, hs.Services["relay"].Unpublished, "")
+	assert.Equal(t, healthCritical, hs.Status, "")
+	assert.Equal(t, 60.0, hs.DataAge["serverSummary"], "")
+
+	commitRecords("serverSummary",
+		"2017.07.04 14:45:41.639|10000|4|103.25.23.75|11015|10000|1|1",
+		"2017.07.04 14:45:41.639|10001|4|175.102.132.81|11015|10001|1|1",
+	)
+	assert.Equal(t, healthOk, buildHealth(now).Status, "")
+	assert.Equal(t, healthDegraded, buildHealth(now.Add(time.Hour)).Status, "")
+}
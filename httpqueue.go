@@ -0,0 +1,131 @@
+// httpqueue
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// httpQueue 按顺序POST已编码的批次, 供直接写存储的sink使用
+// 网络错误/5xx/429重试, 其他4xx说明数据本身有问题, 丢弃该批; 未发出的批次最多保留retention个
+type httpQueue struct {
+	client    *http.Client
+	url       string
+	header    http.Header
+	user      string
+	password  string
+	retries   int
+	retryWait time.Duration
+	retention int
+	pending   [][]byte
+	dropped   prometheus.Counter
+	buffered  prometheus.Gauge
+}
+
+func newHTTPQueue(url string, timeout, retries, retention int) *httpQueue {
+	hq := &httpQueue{
+		client:    &http.Client{Timeout: time.Duration(timeout) * time.Second},
+		url:       url,
+		header:    make(http.Header),
+		retries:   retries,
+		retryWait: time.Second,
+		retention: retention,
+	}
+	if hq.client.Timeout <= 0 {
+		hq.client.Timeout = 10 * time.Second
+	}
+	if hq.retries <= 0 {
+		hq.retries = 3
+	}
+	if hq.retention <= 0 {
+		hq.retention = 100
+	}
+	return hq
+}
+
+// httpError 服务端的响应
+type httpError struct {
+	status int
+	body   string
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("%d %s", e.status, e.body)
+}
+
+func (e *httpError) retryable() bool {
+	return e.status >= 500 || e.status == http.StatusTooManyRequests
+}
+
+func (hq *httpQueue) post(body []byte) error {
+	req, err := http.NewRequest("POST", hq.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, vs := range hq.header {
+		req.Header[k] = vs
+	}
+	if hq.user != "" {
+		req.SetBasicAuth(hq.user, hq.password)
+	}
+
+	rsp, err := hq.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(rsp.Body, 512))
+	if rsp.StatusCode/100 != 2 {
+		return &httpError{status: rsp.StatusCode, body: strings.TrimSpace(string(msg))}
+	}
+	return nil
+}
+
+func (hq *httpQueue) send(body []byte) (keep bool, err error) {
+	for i := 0; i < hq.retries; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(i) * hq.retryWait)
+		}
+		err = hq.post(body)
+		if err == nil {
+			return false, nil
+		}
+		if he, ok := err.(*httpError); ok && !he.retryable() {
+			hq.dropped.Inc()
+			return false, err
+		}
+	}
+	return true, err
+}
+
+// push 加入一批并按顺序补写, 遇到需要重试的失败就停下, 留到下次
+func (hq *httpQueue) push(body []byte) error {
+	if body != nil {
+		hq.pending = append(hq.pending, body)
+	}
+	if n := len(hq.pending) - hq.retention; n > 0 {
+		hq.dropped.Add(float64(n))
+		hq.pending = append([][]byte{}, hq.pending[n:]...)
+	}
+
+	var firstErr error
+	for len(hq.pending) > 0 {
+		keep, err := hq.send(hq.pending[0])
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if keep {
+			break
+		}
+		hq.pending = hq.pending[1:]
+	}
+	hq.buffered.Set(float64(len(hq.pending)))
+	return firstErr
+}
@@ -0,0 +1,137 @@
+// impact
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var ( //impact
+	impact_rootCause = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "impact",
+			Name:      "root_cause",
+			Help:      "host is unhealthy and root cause of its sub-services.",
+		},
+		[]string{
+			"HostID",
+		},
+	)
+	impact_degraded = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "impact",
+			Name:      "degraded_dependents",
+			Help:      "sum of unhealthy sub-services under the host.",
+		},
+		[]string{
+			"HostID",
+		},
+	)
+)
+
+func regImpact() {
+	prometheus.MustRegister(impact_rootCause)
+	prometheus.MustRegister(impact_degraded)
+}
+
+// 以Host为根的故障分组
+type impactGroup struct {
+	HostID     string   `json:"hostId"`
+	HostStatus string   `json:"hostStatus"`
+	Dependents []string `json:"dependents"` // Host下所有子服务
+	Degraded   []string `json:"degraded"`   // 其中不健康的子服务, 不再单独告警
+}
+
+type impactReport struct {
+	RootCauses []*impactGroup `json:"rootCauses"`
+	Problems   []string       `json:"problems"` // 未归入Host的独立故障节点
+}
+
+var lastImpact struct {
+	sync.RWMutex
+	report *impactReport
+}
+
+// analyzeImpact Host不健康时, 其DHT/SPS/ANPS/CM子服务的故障归到Host之下
+func analyzeImpact(tp *topology) *impactReport {
+	ir := &impactReport{RootCauses: []*impactGroup{}, Problems: []string{}}
+	groups := make(map[string]*impactGroup)
+	for _, n := range tp.Nodes {
+		if n.Type == "host" && n.Status == nodeUnhealthy {
+			g := &impactGroup{HostID: n.NodeID, HostStatus: n.Status, Dependents: []string{}, Degraded: []string{}}
+			groups[n.NodeID] = g
+			ir.RootCauses = append(ir.RootCauses, g)
+		}
+	}
+	for _, n := range tp.Nodes {
+		if hostChildTypes[n.Type] {
+			if g, ok := groups[n.HostID]; ok {
+				g.Dependents = append(g.Dependents, n.ID)
+				if n.Status == nodeUnhealthy {
+					g.Degraded = append(g.Degraded, n.ID)
+				}
+				continue
+			}
+		}
+		if n.Status == nodeUnhealthy && !(n.Type == "host" && groups[n.NodeID] != nil) {
+			ir.Problems = append(ir.Problems, n.ID)
+		}
+	}
+	sort.Strings(ir.Problems)
+	return ir
+}
+
+func (ir *impactReport) events() []event {
+	evs := make([]event, 0, len(ir.RootCauses)+len(ir.Problems))
+	for _, g := range ir.RootCauses {
+		evs = append(evs, event{
+			Key:      topoID("host", g.HostID),
+			Severity: "critical",
+			Message:  fmt.Sprintf("root cause: host %s unhealthy, %d dependent services degraded", g.HostID, len(g.Degraded)),
+			Nodes:    g.Degraded,
+		})
+	}
+	for _, id := range ir.Problems {
+		evs = append(evs, event{
+			Key:      id,
+			Severity: "warning",
+			Message:  id + " unhealthy",
+		})
+	}
+	return evs
+}
+
+func updateImpact() {
+	ir := analyzeImpact(buildTopology())
+	lastImpact.Lock()
+	lastImpact.report = ir
+	lastImpact.Unlock()
+
+	events.update("impact", ir.events())
+
+	if globeCfg.Output.Prometheus || globeCfg.Output.PushGateway {
+		impact_rootCause.Reset()
+		impact_degraded.Reset()
+		for _, g := range ir.RootCauses {
+			impact_rootCause.WithLabelValues(g.HostID).Set(1)
+			impact_degraded.WithLabelValues(g.HostID).Set(float64(len(g.Degraded)))
+		}
+	}
+}
+
+// /api/v1/impact
+func impactHandler(w http.ResponseWriter, r *http.Request) {
+	lastImpact.RLock()
+	ir := lastImpact.report
+	lastImpact.RUnlock()
+	if ir == nil {
+		ir = analyzeImpact(buildTopology())
+	}
+	writeJSON(w, http.StatusOK, ir)
+}
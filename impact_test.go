@@ -0,0 +1,40 @@
+// impact_test
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stvp/assert"
+)
+
+func TestAnalyzeImpact(t *testing.T) {
+	commitRecords("serverSummary",
+		"2017.07.04 14:45:41.639|10000|4|103.25.23.75|11015|10000|1|1",
+		"2017.07.04 14:45:41.639|10001|4|175.102.132.81|11015|10001|1|0",
+		"2017.07.04 14:45:41.639|20005|1|175.102.132.81|10021|0|1|0",
+		"2017.07.04 14:45:41.639|40001|3|175.102.132.81|10012|0|1|0",
+		"2017.07.04 14:45:41.639|50001|6|175.102.132.81|10032|0|1|1",
+		"2017.07.04 14:45:41.639|20001|1|103.25.23.75|10021|0|1|0",
+	)
+	commitRecords("sps")
+	ir := analyzeImpact(buildTopology())
+
+	assert.Equal(t, 1, len(ir.RootCauses), "")
+	assert.Equal(t, "10001", ir.RootCauses[0].HostID, "")
+	assert.Equal(t, []string{"cm:40001", "dht:20005", "sps:50001"}, ir.RootCauses[0].Dependents, "")
+	assert.Equal(t, []string{"cm:40001", "dht:20005"}, ir.RootCauses[0].Degraded, "")
+	assert.Equal(t, []string{"dht:20001"}, ir.Problems, "")
+
+	start := time.Now()
+	events.update("impact-test", ir.events())
+	events.update("impact-test", ir.events())
+	evs := events.since("impact-test", start)
+	assert.Equal(t, 2, len(evs), "")
+	assert.Equal(t, "root cause: host 10001 unhealthy, 2 dependent services degraded", evs[0].Message, "")
+
+	events.update("impact-test", nil)
+	evs = events.since("impact-test", start)
+	assert.Equal(t, 4, len(evs), "")
+	assert.Equal(t, alertResolved, evs[3].State, "")
+}
@@ -0,0 +1,100 @@
+// influx
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var ( //influx
+	influx_dropped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "p2p",
+			Subsystem: "ops",
+			Name:      "influx_dropped_batches_total",
+			Help:      "batches dropped because InfluxDB rejected them or too many were unsent.",
+		},
+	)
+	influx_pending = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "ops",
+			Name:      "influx_pending_batches",
+			Help:      "batches waiting to be written to InfluxDB.",
+		},
+	)
+)
+
+func regInflux() {
+	prometheus.MustRegister(influx_dropped)
+	prometheus.MustRegister(influx_pending)
+}
+
+// influxSink 直接写InfluxDB, measurement/tag/field与Telegraf输出相同
+type influxSink struct {
+	*httpQueue
+	gzip bool
+}
+
+func newInfluxSink() (*influxSink, error) {
+	cfg := &globeCfg.Influx
+	q := url.Values{}
+	q.Set("precision", "ns")
+	base := strings.TrimRight(cfg.Url, "/")
+	var hq *httpQueue
+	switch cfg.Version {
+	case 0, 1:
+		if cfg.Db == "" {
+			return nil, fmt.Errorf("influx: db required")
+		}
+		q.Set("db", cfg.Db)
+		if cfg.Rp != "" {
+			q.Set("rp", cfg.Rp)
+		}
+		hq = newHTTPQueue(base+"/write?"+q.Encode(), cfg.Timeout, cfg.Retries, cfg.Retention)
+		hq.user, hq.password = cfg.Username, cfg.Password
+	case 2:
+		if cfg.Org == "" || cfg.Bucket == "" {
+			return nil, fmt.Errorf("influx: org and bucket required")
+		}
+		q.Set("org", cfg.Org)
+		q.Set("bucket", cfg.Bucket)
+		hq = newHTTPQueue(base+"/api/v2/write?"+q.Encode(), cfg.Timeout, cfg.Retries, cfg.Retention)
+		hq.header.Set("Authorization", "Token "+cfg.Token)
+	default:
+		return nil, fmt.Errorf("influx: unsupported version %d", cfg.Version)
+	}
+	hq.header.Set("Content-Type", "text/plain; charset=utf-8")
+	if cfg.Gzip {
+		hq.header.Set("Content-Encoding", "gzip")
+	}
+	hq.dropped, hq.buffered = influx_dropped, influx_pending
+	return &influxSink{httpQueue: hq, gzip: cfg.Gzip}, nil
+}
+
+func (is *influxSink) Name() string { return "influx" }
+
+func (is *influxSink) Write(ss []sample) error {
+	lines := telegrafLines(ss)
+	if len(lines) == 0 {
+		return is.push(nil)
+	}
+	if !is.gzip {
+		return is.push(lines)
+	}
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	if _, err := zw.Write(lines); err != nil {
+		return fmt.Errorf("influx: gzip: %v", err)
+	}
+	// Close写出剩余数据和校验, 失败时不能把不完整的body放进重试队列
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("influx: gzip: %v", err)
+	}
+	return is.push(b.Bytes())
+}
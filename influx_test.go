@@ -0,0 +1,121 @@
+// influx_test
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stvp/assert"
+)
+
+func TestInfluxSink(t *testing.T) {
+	var bodies []string
+	status := []int{503, 204, 400, 204}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v2/write", r.URL.Path, "")
+		assert.Equal(t, "ops", r.URL.Query().Get("org"), "")
+		assert.Equal(t, "p2p", r.URL.Query().Get("bucket"), "")
+		assert.Equal(t, "ns", r.URL.Query().Get("precision"), "")
+		assert.Equal(t, "Token secret", r.Header.Get("Authorization"), "")
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"), "")
+		zr, err := gzip.NewReader(r.Body)
+		assert.Nil(t, err, "")
+		b, _ := ioutil.ReadAll(zr)
+		bodies = append(bodies, string(b))
+		w.WriteHeader(status[0])
+		status = status[1:]
+	}))
+	defer srv.Close()
+
+	saved := globeCfg.Influx
+	defer func() { globeCfg.Influx = saved }()
+	globeCfg.Influx.Url = srv.URL + "/"
+	globeCfg.Influx.Version = 2
+	globeCfg.Influx.Org = "ops"
+	globeCfg.Influx.Bucket = "p2p"
+	globeCfg.Influx.Token = "secret"
+	globeCfg.Influx.Gzip = true
+	globeCfg.Influx.Retries = 2
+	is, err := newInfluxSink()
+	assert.Nil(t, err, "")
+	is.retryWait = 0
+
+	// 503后重试成功
+	assert.Nil(t, is.Write(cmSamples("1")), "")
+	assert.Equal(t, 2, len(bodies), "")
+	assert.Equal(t, bodies[0], bodies[1], "")
+	assert.True(t, strings.HasPrefix(bodies[1], "p2p_cm,"), "")
+	assert.True(t, strings.Contains(bodies[1], ",id=1 "), "")
+
+	// 400不重试, 丢弃该批
+	assert.NotNil(t, is.Write(cmSamples("2")), "")
+	assert.Equal(t, 3, len(bodies), "")
+	assert.Equal(t, 0, len(is.pending), "")
+}
+
+func TestInfluxRetention(t *testing.T) {
+	var paths []string
+	down := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path+"?"+r.URL.RawQuery)
+		u, p, _ := r.BasicAuth()
+		assert.Equal(t, "admin:pw", u+":"+p, "")
+		if down {
+			w.WriteHeader(500)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		assert.True(t, strings.Contains(string(b), ",id=2 ") || strings.Contains(string(b), ",id=3 "), "")
+		w.WriteHeader(204)
+	}))
+	defer srv.Close()
+
+	saved := globeCfg.Influx
+	defer func() { globeCfg.Influx = saved }()
+	globeCfg.Influx.Url = srv.URL
+	globeCfg.Influx.Version = 1
+	globeCfg.Influx.Db = "p2p"
+	globeCfg.Influx.Rp = "week"
+	globeCfg.Influx.Username = "admin"
+	globeCfg.Influx.Password = "pw"
+	globeCfg.Influx.Gzip = false
+	globeCfg.Influx.Retries = 1
+	globeCfg.Influx.Retention = 2
+	is, err := newInfluxSink()
+	assert.Nil(t, err, "")
+
+	for _, id := range []string{"1", "2"} {
+		assert.NotNil(t, is.Write(cmSamples(id)), "")
+	}
+	assert.Equal(t, 2, len(is.pending), "")
+	assert.Equal(t, "/write?db=p2p&precision=ns&rp=week", paths[0], "")
+
+	// 恢复后按顺序补写, 最旧的一批已被丢弃
+	down = false
+	assert.Nil(t, is.Write(cmSamples("3")), "")
+	assert.Equal(t, 0, len(is.pending), "")
+	assert.Equal(t, 4, len(paths), "")
+
+	globeCfg.Influx.Db = ""
+	_, err = newInfluxSink()
+	assert.NotNil(t, err, "")
+}
+
+func TestRedactCfg(t *testing.T) {
+	gwc := *globeCfg
+	gwc.Influx.Password, gwc.Influx.Token = "secret-pw", "secret-token"
+	gwc.RemoteWrite.BearerToken = "secret-bearer"
+	gwc.Otlp.Headers = map[string]string{"Authorization": "Basic abc"}
+
+	s := fmt.Sprint(redactCfg(gwc))
+	for _, secret := range []string{"secret-pw", "secret-token", "secret-bearer", "Basic abc"} {
+		assert.False(t, strings.Contains(s, secret), secret)
+	}
+	// 原配置不变
+	assert.Equal(t, "Basic abc", gwc.Otlp.Headers["Authorization"], "")
+}
@@ -0,0 +1,98 @@
+// lineproto
+package main
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfluxDB line protocol, 见 https://docs.influxdata.com/influxdb/v1.8/write_protocols/line_protocol_reference/
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	keyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+	stringEscaper      = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
+type lineField struct {
+	Key   string
+	Value interface{} // int64 float64 bool string
+}
+
+type linePoint struct {
+	Measurement string
+	Tags        []label
+	Fields      []lineField
+	Time        time.Time // 为零时不带时间戳, 由服务端取接收时间
+}
+
+func (p *linePoint) addField(key string, v interface{}) {
+	p.Fields = append(p.Fields, lineField{Key: key, Value: v})
+}
+
+func encodeFieldValue(b *bytes.Buffer, v interface{}) bool {
+	switch v := v.(type) {
+	case int64:
+		b.WriteString(strconv.FormatInt(v, 10))
+		b.WriteByte('i')
+	case int:
+		b.WriteString(strconv.Itoa(v))
+		b.WriteByte('i')
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+		b.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	case string:
+		b.WriteByte('"')
+		b.WriteString(stringEscaper.Replace(v))
+		b.WriteByte('"')
+	default:
+		return false
+	}
+	return true
+}
+
+// encode 写出一行, tag按key排序, 空tag值和无法表示的field跳过; 没有field时不写
+func (p *linePoint) encode(b *bytes.Buffer) {
+	var fb bytes.Buffer
+	for _, f := range p.Fields {
+		n := fb.Len()
+		if n > 0 {
+			fb.WriteByte(',')
+		}
+		fb.WriteString(keyEscaper.Replace(f.Key))
+		fb.WriteByte('=')
+		if !encodeFieldValue(&fb, f.Value) {
+			fb.Truncate(n)
+		}
+	}
+	if fb.Len() == 0 {
+		return
+	}
+
+	b.WriteString(measurementEscaper.Replace(p.Measurement))
+	tags := append([]label{}, p.Tags...)
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	for _, t := range tags {
+		if t.Value == "" {
+			continue
+		}
+		b.WriteByte(',')
+		b.WriteString(keyEscaper.Replace(t.Name))
+		b.WriteByte('=')
+		b.WriteString(keyEscaper.Replace(t.Value))
+	}
+	b.WriteByte(' ')
+	b.Write(fb.Bytes())
+	if !p.Time.IsZero() {
+		b.WriteByte(' ')
+		b.WriteString(strconv.FormatInt(p.Time.UnixNano(), 10))
+	}
+	b.WriteByte('\n')
+}
@@ -0,0 +1,34 @@
+// lineproto_test
+package main
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stvp/assert"
+)
+
+func TestLinePoint(t *testing.T) {
+	var b bytes.Buffer
+	p := &linePoint{
+		Measurement: "p2p relay,x",
+		Tags:        []label{{Name: "node id", Value: "a=b,c"}, {Name: "HostId", Value: ""}, {Name: "addr", Value: "1.2.3.4:9000"}},
+		Time:        time.Unix(1499150741, 639000000),
+	}
+	p.addField("n", int64(-3))
+	p.addField("ratio", 0.25)
+	p.addField("nan", math.NaN())
+	p.addField("ok", true)
+	p.addField("msg", `say "hi" \ bye`)
+	p.encode(&b)
+	assert.Equal(t, `p2p\ relay\,x,addr=1.2.3.4:9000,node\ id=a\=b\,c n=-3i,ratio=0.25,ok=true,msg="say \"hi\" \\ bye" 1499150741639000000`+"\n", b.String(), "")
+
+	// 没有可写的field时不输出
+	b.Reset()
+	p = &linePoint{Measurement: "m"}
+	p.addField("inf", math.Inf(1))
+	p.encode(&b)
+	assert.Equal(t, "", b.String(), "")
+}
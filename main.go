@@ -3,14 +3,12 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"ebase"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -32,20 +30,168 @@ type GWConfig struct {
 	Gw struct {
 		Addr           string `yaml:"addr"`
 		HttpListenPort int    `yaml:"httpListenPort"`
+		Api            bool   `yaml:"api"`
 	}
 	Output struct {
-		Prometheus      bool   `yaml:"prometheus"`
-		Telegraf        bool   `yaml:"telegraf"`
-		TelegrafAddr    string `yaml:"telegrafAddr"`
-		PushGateway     bool   `yaml:"pushGateway"`
-		PushGatewayAddr string `yaml:"pushGatewayAddr"`
-		JobName         string `yaml:"jobName"`
+		Prometheus       bool   `yaml:"prometheus"`
+		Telegraf         bool   `yaml:"telegraf"`
+		TelegrafAddr     string `yaml:"telegrafAddr"`
+		TelegrafBuffer   int    `yaml:"telegrafBuffer"`  // 连接断开时最多缓存的行数
+		TelegrafTimeout  int    `yaml:"telegrafTimeout"` // 秒, 连接和写超时
+		TelegrafPayload  int    `yaml:"telegrafPayload"` // udp/unixgram数据报的最大字节数
+		TelegrafCA       string `yaml:"telegrafCA"`      // tls://时校验Telegraf证书的CA
+		TelegrafCert     string `yaml:"telegrafCert"`    // tls://时的客户端证书
+		TelegrafKey      string `yaml:"telegrafKey"`
+		TelegrafInsecure bool   `yaml:"telegrafInsecure"`
+		PushGateway      bool   `yaml:"pushGateway"`
+		PushGatewayAddr  string `yaml:"pushGatewayAddr"`
+		JobName          string `yaml:"jobName"`
+	}
+	Influx struct {
+		Enable    bool   `yaml:"enable"`
+		Url       string `yaml:"url"`
+		Version   int    `yaml:"version"` // 1: /write  2: /api/v2/write
+		Db        string `yaml:"db"`
+		Rp        string `yaml:"rp"`
+		Username  string `yaml:"username"`
+		Password  string `yaml:"password"`
+		Org       string `yaml:"org"`
+		Bucket    string `yaml:"bucket"`
+		Token     string `yaml:"token"`
+		Gzip      bool   `yaml:"gzip"`
+		Timeout   int    `yaml:"timeout"`
+		Retries   int    `yaml:"retries"`
+		Retention int    `yaml:"retention"`
+	}
+	RemoteWrite struct {
+		Enable      bool   `yaml:"enable"`
+		Url         string `yaml:"url"`
+		Username    string `yaml:"username"`
+		Password    string `yaml:"password"`
+		BearerToken string `yaml:"bearerToken"`
+		Timeout     int    `yaml:"timeout"`
+		Retries     int    `yaml:"retries"`
+		Retention   int    `yaml:"retention"`
+	}
+	Otlp struct {
+		Enable   bool              `yaml:"enable"`
+		Protocol string            `yaml:"protocol"` // http grpc
+		Endpoint string            `yaml:"endpoint"` // host:port
+		UrlPath  string            `yaml:"urlPath"`  // http时默认/v1/metrics
+		Insecure bool              `yaml:"insecure"`
+		Gzip     bool              `yaml:"gzip"`
+		Headers  map[string]string `yaml:"headers"`
+		Cluster  string            `yaml:"cluster"` // resource属性cluster
+		Timeout  int               `yaml:"timeout"`
+	}
+	Graphite struct {
+		Enable    bool              `yaml:"enable"`
+		Addr      string            `yaml:"addr"`      // host:port, 明文2003, pickle 2004
+		Protocol  string            `yaml:"protocol"`  // plaintext pickle
+		Template  string            `yaml:"template"`  // 路径模板, 如p2p.{cluster}.host.{hostId}.{field}
+		Templates map[string]string `yaml:"templates"` // 按action覆盖template
+		Cluster   string            `yaml:"cluster"`
+		Buffer    int               `yaml:"buffer"`  // 连接断开时最多缓存的消息数
+		Timeout   int               `yaml:"timeout"` // 秒, 连接和写超时
+	}
+	Statsd struct {
+		Enable    bool   `yaml:"enable"`
+		Addr      string `yaml:"addr"` // host:port, udp
+		Prefix    string `yaml:"prefix"`
+		Dogstatsd bool   `yaml:"dogstatsd"` // 节点ID/IP/HostID作为DogStatsD标签, 否则放在指标名里
+		Payload   int    `yaml:"payload"`   // 每个数据报的最大字节数
+		Buffer    int    `yaml:"buffer"`
+	}
+	Zabbix struct {
+		Enable    bool   `yaml:"enable"`
+		Addr      string `yaml:"addr"`      // Zabbix server/proxy trapper, host:10051
+		Host      string `yaml:"host"`      // 监控项所在的Zabbix主机名
+		Prefix    string `yaml:"prefix"`    // 监控项key前缀
+		Discovery int    `yaml:"discovery"` // 秒, 节点清单不变时LLD的重发间隔
+		Timeout   int    `yaml:"timeout"`
 	}
 	Rest struct {
 		Test   bool   `yaml:"test"`
 		Vdn    string `yaml:"vdn"`
 		Period int    `yaml:"period"`
 	}
+	Health struct {
+		DegradedUnhealthy int     `yaml:"degradedUnhealthy"`
+		CriticalRatio     float64 `yaml:"criticalRatio"`
+		MaxDataAge        int     `yaml:"maxDataAge"`
+	}
+	Tsdb struct {
+		Enable     bool   `yaml:"enable"`
+		Path       string `yaml:"path"`
+		Retention  int    `yaml:"retention"`
+		Resolution int    `yaml:"resolution"`
+		Flush      int    `yaml:"flush"`
+	}
+	Rollup struct {
+		Enable        bool   `yaml:"enable"`
+		Path          string `yaml:"path"`
+		HourRetention int    `yaml:"hourRetention"`
+		DayRetention  int    `yaml:"dayRetention"`
+		Flush         int    `yaml:"flush"`
+	}
+	Baseline struct {
+		Enable    bool     `yaml:"enable"`
+		Path      string   `yaml:"path"`
+		Metrics   []string `yaml:"metrics"`
+		Alpha     float64  `yaml:"alpha"`
+		MinWeeks  int      `yaml:"minWeeks"`
+		Threshold float64  `yaml:"threshold"`
+		Flush     int      `yaml:"flush"`
+	}
+	Anomaly struct {
+		Enable      bool     `yaml:"enable"`
+		Path        string   `yaml:"path"`
+		Metrics     []string `yaml:"metrics"`
+		Alpha       float64  `yaml:"alpha"`
+		Sensitivity float64  `yaml:"sensitivity"`
+		Warmup      int      `yaml:"warmup"`
+		Seasonal    bool     `yaml:"seasonal"`
+		Flush       int      `yaml:"flush"`
+	}
+	Bandwidth struct {
+		Enable     bool   `yaml:"enable"`
+		Path       string `yaml:"path"`
+		Unit       string `yaml:"unit"`
+		BillingDay int    `yaml:"billingDay"`
+		Retention  int    `yaml:"retention"`
+		Flush      int    `yaml:"flush"`
+	}
+	Sla struct {
+		Enable    bool   `yaml:"enable"`
+		Path      string `yaml:"path"`
+		Retention int    `yaml:"retention"`
+		Flush     int    `yaml:"flush"`
+	}
+	DhtRing struct {
+		RouteDivergence float64 `yaml:"routeDivergence"`
+		GetSetSkew      float64 `yaml:"getSetSkew"`
+	}
+	Push struct {
+		LossRatio     float64 `yaml:"lossRatio"`
+		BacklogGrowth int     `yaml:"backlogGrowth"`
+	}
+	Capacity struct {
+		Threshold float64 `yaml:"threshold"`
+		Method    string  `yaml:"method"`
+		History   int     `yaml:"history"`
+	}
+	Balance struct {
+		GroupBy  string  `yaml:"groupBy"`
+		Overload float64 `yaml:"overload"`
+		Relays   []struct {
+			Id     string `yaml:"id"`
+			Region string `yaml:"region"`
+			Isp    string `yaml:"isp"`
+		} `yaml:"relays"`
+	}
+	Report struct {
+		Dir string `yaml:"dir"`
+	}
 	Logger struct {
 		Filename   string `yaml:"filename"`
 		MaxSize    int    `yaml:"maxSize"`
@@ -64,6 +210,7 @@ type GWConfig struct {
 		Ps             string `yaml:"ps"`
 		Callmgr        string `yaml:"callmgr"`
 	}
+	Sinks map[string]sinkConfig `yaml:"sinks"` // 按sink名: prometheus telegraf influx remoteWrite otlp graphite statsd zabbix
 }
 
 var globeCfg *GWConfig
@@ -131,35 +278,8 @@ var ( //statistic.serverSummary.action
 )
 
 func regServerSummary() {
-	prometheus.MustRegister(serverSummary_published)
-	prometheus.MustRegister(serverSummary_healthy)
-}
-
-func extractServerSummary(svrSum []string) error {
-	//时间 节点ID 服务器类型 IP port 所属hostID *是否发布 *是否健康
-	svcType := svrSum[2]
-	nodeID := svrSum[1]
-	ip := svrSum[3]
-	port := svrSum[4]
-	hostID := svrSum[5]
-	published, _ := strconv.Atoi(svrSum[6])
-	healthy, _ := strconv.Atoi(svrSum[7])
-	if globeCfg.Output.Prometheus || globeCfg.Output.PushGateway {
-		serverSummary_published.WithLabelValues(svcType, nodeID, ip, port, hostID).Set(float64(published))
-		serverSummary_healthy.WithLabelValues(svcType, nodeID, ip, port, hostID).Set(float64(healthy))
-	}
-
-	if globeCfg.Output.Telegraf {
-		mx := fmt.Sprintf("p2p_serverSummary,svcType=%s,nodeId=%s,addr=%s:%s,hostId=%s published=%d,healthy=%d\n",
-			svcType,
-			nodeID,
-			ip, port,
-			hostID,
-			published,
-			healthy)
-		collectBuf.WriteString(mx)
-	}
-	return nil
+	regGauge("serverSummary_published", "p2p_serverSummary_published", serverSummary_published)
+	regGauge("serverSummary_healthy", "p2p_serverSummary_healthy", serverSummary_healthy)
 }
 
 var ( //statistic.userStatistic.action
@@ -224,58 +344,12 @@ var ( //statistic.userStatistic.action
 )
 
 func regUserStatistic() {
-	prometheus.MustRegister(userStatistic_online)
-	prometheus.MustRegister(userStatistic_anonym)
-	prometheus.MustRegister(userStatistic_activable)
-	prometheus.MustRegister(userStatistic_login)
-	prometheus.MustRegister(userStatistic_logout)
-	prometheus.MustRegister(userStatistic_dcategory)
-}
-
-func extractUserStatistic(userS []string) error {
-	//时间 *在线用户数 *匿名用户数 *可激活用户数 *最近三分钟登录用户数 *最近三分钟登出用户数 分类终端在线用户数
-	onlineUserNum, _ := strconv.Atoi(userS[1])
-	anonymUserNum, _ := strconv.Atoi(userS[2])
-	activableUserNum, _ := strconv.Atoi(userS[3])
-	loginUserNum, _ := strconv.Atoi(userS[4])
-	logoutUserNum, _ := strconv.Atoi(userS[5])
-	strss := strings.Replace(userS[6], "[", "", -1)
-	strss = strings.Replace(strss, "]", "", -1)
-	infos := strings.Split(strss, ",")
-	var darray = make([]int, 10)
-	for i, v := range infos {
-		aint, _ := strconv.Atoi(v)
-		darray[i] = aint
-	}
-
-	if globeCfg.Output.Prometheus || globeCfg.Output.PushGateway {
-		userStatistic_online.Set(float64(onlineUserNum))
-		userStatistic_anonym.Set(float64(anonymUserNum))
-		userStatistic_activable.Set(float64(activableUserNum))
-		userStatistic_login.Set(float64(loginUserNum))
-		userStatistic_logout.Set(float64(logoutUserNum))
-		userStatistic_dcategory.WithLabelValues("X1").Set(float64(darray[0]))
-		userStatistic_dcategory.WithLabelValues("N7/N8").Set(float64(darray[1]))
-		userStatistic_dcategory.WithLabelValues("IOS").Set(float64(darray[2]))
-		userStatistic_dcategory.WithLabelValues("Android").Set(float64(darray[3]))
-		userStatistic_dcategory.WithLabelValues("WEB_GW").Set(float64(darray[4]))
-		userStatistic_dcategory.WithLabelValues("PC").Set(float64(darray[5]))
-		userStatistic_dcategory.WithLabelValues("AGENT").Set(float64(darray[6]))
-		userStatistic_dcategory.WithLabelValues("PSTN_GW").Set(float64(darray[7]))
-		userStatistic_dcategory.WithLabelValues("LINUX").Set(float64(darray[8]))
-		userStatistic_dcategory.WithLabelValues("CLOUD_GW").Set(float64(darray[9]))
-	}
-
-	if globeCfg.Output.Telegraf {
-		mx := fmt.Sprintf("p2p_userStatistic,host=all online=%d,anonym=%d,activable=%d,loginX=%d,logoutX=%d\n",
-			onlineUserNum,
-			anonymUserNum,
-			activableUserNum,
-			loginUserNum,
-			logoutUserNum)
-		collectBuf.WriteString(mx)
-	}
-	return nil
+	regGauge("userStatistic_online", "p2p_userStatistic_online", userStatistic_online)
+	regGauge("userStatistic_anonym", "p2p_userStatistic_anonym", userStatistic_anonym)
+	regGauge("userStatistic_activable", "p2p_userStatistic_activable", userStatistic_activable)
+	regGauge("userStatistic_login", "p2p_userStatistic_new_login", userStatistic_login)
+	regGauge("userStatistic_logout", "p2p_userStatistic_new_logout", userStatistic_logout)
+	regGauge("userStatistic_dcategory", "p2p_userStatistic_category", userStatistic_dcategory)
 }
 
 var ( //statistic.callStatistic.action
@@ -345,49 +419,14 @@ var ( //statistic.callStatistic.action
 )
 
 func regCallStatistic() {
-	prometheus.MustRegister(callStatistic_onphone)
-	prometheus.MustRegister(callStatistic_onphoneV)
-	prometheus.MustRegister(callStatistic_onphoneA)
-	prometheus.MustRegister(callStatistic_callTraffic)
-	prometheus.MustRegister(callStatistic_blockedCall)
-	prometheus.MustRegister(callStatistic_releasedCall)
-	prometheus.MustRegister(callStatistic_breakedCall)
-
-}
+	regGauge("callStatistic_onphone", "p2p_callStatistic_onphone", callStatistic_onphone)
+	regGauge("callStatistic_onphoneV", "p2p_callStatistic_onphone_video", callStatistic_onphoneV)
+	regGauge("callStatistic_onphoneA", "p2p_callStatistic_onphone_audio", callStatistic_onphoneA)
+	regGauge("callStatistic_callTraffic", "p2p_callStatistic_new_traffic", callStatistic_callTraffic)
+	regGauge("callStatistic_blockedCall", "p2p_callStatistic_new_blocked_call", callStatistic_blockedCall)
+	regGauge("callStatistic_releasedCall", "p2p_callStatistic_new_released_call", callStatistic_releasedCall)
+	regGauge("callStatistic_breakedCall", "p2p_callStatistic_new_broken_call", callStatistic_breakedCall)
 
-func extractCallStatistic(callS []string) error {
-	//  时间 *当前通话并发总数 *当前视频通话总数 *当前音频通话总数 *最近3分钟通话量 *最近3分钟未接通数 *最近3分钟正常挂断数 *最近3分钟异常挂断数
-	onphoneNum, _ := strconv.Atoi(callS[1])
-	onphoneVNum, _ := strconv.Atoi(callS[2])
-	onphoneANum, _ := strconv.Atoi(callS[3])
-	callTrafficNum, _ := strconv.Atoi(callS[4])
-	blockCallNum, _ := strconv.Atoi(callS[5])
-	callReleasedNum, _ := strconv.Atoi(callS[6])
-	callBreakedNum, _ := strconv.Atoi(callS[7])
-
-	if globeCfg.Output.Prometheus || globeCfg.Output.PushGateway {
-		callStatistic_onphone.Set(float64(onphoneNum))
-
-		callStatistic_onphoneV.Set(float64(onphoneVNum))
-		callStatistic_onphoneA.Set(float64(onphoneANum))
-		callStatistic_callTraffic.Set(float64(callTrafficNum))
-		callStatistic_blockedCall.Set(float64(blockCallNum))
-		callStatistic_releasedCall.Set(float64(callReleasedNum))
-		callStatistic_breakedCall.Set(float64(callBreakedNum))
-	}
-
-	if globeCfg.Output.Telegraf {
-		mx := fmt.Sprintf("p2p_callStatistic,host=all onphone=%d,onvideo=%d,onaudio=%d,trafficX=%d,blockedX=%d,releasedX=%d,brokenX=%d\n",
-			onphoneNum,
-			onphoneVNum,
-			onphoneANum,
-			callTrafficNum,
-			blockCallNum,
-			callReleasedNum,
-			callBreakedNum)
-		collectBuf.WriteString(mx)
-	}
-	return nil
 }
 
 //TODO:statistic.acd.action
@@ -691,109 +730,27 @@ var ( //statistic.host.action
 )
 
 func regHost() {
-	prometheus.MustRegister(host_healthy)
-	prometheus.MustRegister(host_fixedUser)
-	prometheus.MustRegister(host_onlineUser)
-	prometheus.MustRegister(host_onlineSeat)
-	prometheus.MustRegister(host_onlineAnonym)
-	prometheus.MustRegister(host_untreatedTask)
-	prometheus.MustRegister(host_login)
-	prometheus.MustRegister(host_logout)
-	prometheus.MustRegister(host_loginUser)
-	prometheus.MustRegister(host_logoutUser)
-	prometheus.MustRegister(host_queryCalled)
-	prometheus.MustRegister(host_queryCalledSuc)
-	prometheus.MustRegister(host_queryCalledDHT)
-	prometheus.MustRegister(host_relayMsg)
-	prometheus.MustRegister(host_relayMsgCAHCESuc)
-	prometheus.MustRegister(host_relayMsgQueryDHT)
-	prometheus.MustRegister(host_relayMsgLocalSuc)
-	prometheus.MustRegister(host_relaySeatMsg)
-	prometheus.MustRegister(host_relayUserQueuePos)
-	prometheus.MustRegister(host_pushAPNS)
-	prometheus.MustRegister(host_pushSilent)
-}
-
-func extractHost(hs []string) error {
-	//  时间 Host节点ID Host IP Host Port *Host是否健康 *额定用户数 *在线用户数 *坐席在线个数 *匿名在线用户数 *工作线程未处理任务数 *最近3分钟登录次数 *最近3分钟登出次数 *最近3分钟登录用户数 *最近3分钟登出用户数 *最近3分钟查询被叫次数 *最近3分钟查询被叫本地命中次数 *最近3分钟查询被叫DHT查询次数 *最近3分钟转发消息次数 *最近3分钟转发消息CAHCE命中次数 *最近3分钟转发消息DHT查询次数 *最近3分钟转发消息本地命中次数 *最近3分钟发送坐席状态消息次数 *最近3分钟发送用户排队位置消息次数 *最近3分钟向APNS通道推送次数 *最近3分钟向静默通道推送次数 在线用户设备分布列表
-	hostId := hs[1]
-	ip := hs[2]
-	port := hs[3]
-	healthy, _ := strconv.Atoi(hs[4])
-	fixedUser, _ := strconv.Atoi(hs[5])
-	onlineUser, _ := strconv.Atoi(hs[6])
-	onlineSeat, _ := strconv.Atoi(hs[7])
-	onlineAnonym, _ := strconv.Atoi(hs[8])
-	untreatedTask, _ := strconv.Atoi(hs[9])
-	login, _ := strconv.Atoi(hs[10])
-	logout, _ := strconv.Atoi(hs[11])
-	loginUser, _ := strconv.Atoi(hs[12])
-	logoutUser, _ := strconv.Atoi(hs[13])
-	queryCalled, _ := strconv.Atoi(hs[14])
-	queryCalledSuc, _ := strconv.Atoi(hs[15])
-	queryCalledDHT, _ := strconv.Atoi(hs[16])
-	relayMsg, _ := strconv.Atoi(hs[17])
-	relayMsgCAHCESuc, _ := strconv.Atoi(hs[18])
-	relayMsgQueryDHT, _ := strconv.Atoi(hs[19])
-	relayMsgLocalSuc, _ := strconv.Atoi(hs[20])
-	relaySeatMsg, _ := strconv.Atoi(hs[21])
-	relayUserQueuePos, _ := strconv.Atoi(hs[22])
-	pushAPNS, _ := strconv.Atoi(hs[23])
-	pushSilent, _ := strconv.Atoi(hs[24])
-
-	if globeCfg.Output.Prometheus || globeCfg.Output.PushGateway {
-		host_healthy.WithLabelValues(hostId, ip, port).Set(float64(healthy))
-		host_fixedUser.WithLabelValues(hostId, ip, port).Set(float64(fixedUser))
-		host_onlineUser.WithLabelValues(hostId, ip, port).Set(float64(onlineUser))
-		host_onlineSeat.WithLabelValues(hostId, ip, port).Set(float64(onlineSeat))
-		host_onlineAnonym.WithLabelValues(hostId, ip, port).Set(float64(onlineAnonym))
-		host_untreatedTask.WithLabelValues(hostId, ip, port).Set(float64(untreatedTask))
-		host_login.WithLabelValues(hostId, ip, port).Set(float64(login))
-		host_logout.WithLabelValues(hostId, ip, port).Set(float64(logout))
-		host_loginUser.WithLabelValues(hostId, ip, port).Set(float64(loginUser))
-		host_logoutUser.WithLabelValues(hostId, ip, port).Set(float64(logoutUser))
-		host_queryCalled.WithLabelValues(hostId, ip, port).Set(float64(queryCalled))
-		host_queryCalledSuc.WithLabelValues(hostId, ip, port).Set(float64(queryCalledSuc))
-		host_queryCalledDHT.WithLabelValues(hostId, ip, port).Set(float64(queryCalledDHT))
-		host_relayMsg.WithLabelValues(hostId, ip, port).Set(float64(relayMsg))
-		host_relayMsgCAHCESuc.WithLabelValues(hostId, ip, port).Set(float64(relayMsgCAHCESuc))
-		host_relayMsgQueryDHT.WithLabelValues(hostId, ip, port).Set(float64(relayMsgQueryDHT))
-		host_relayMsgLocalSuc.WithLabelValues(hostId, ip, port).Set(float64(relayMsgLocalSuc))
-		host_relaySeatMsg.WithLabelValues(hostId, ip, port).Set(float64(relaySeatMsg))
-		host_relayUserQueuePos.WithLabelValues(hostId, ip, port).Set(float64(relayUserQueuePos))
-		host_pushAPNS.WithLabelValues(hostId, ip, port).Set(float64(pushAPNS))
-		host_pushSilent.WithLabelValues(hostId, ip, port).Set(float64(pushSilent))
-	}
-
-	if globeCfg.Output.Telegraf {
-		mx := fmt.Sprintf("p2p_host,id=%s,addr=%s:%s healthy=%d,fixed_user=%d,online_user=%d,online_seat=%d,online_anonym=%d,untreated_task=%d,loginX=%d,logoutX=%d,login_userX=%d,logout_userX=%d,query_calledX=%d,query_called_okX=%d,query_called_DHT_X=%d,relay_msgX=%d,relay_msg_CAHCE_okX=%d,relay_msg_query_DHT_X=%d,relay_msg_local_okX=%d,relay_seat_msgX=%d,relay_user_queue_posX=%d,push_APNS_X=%d,push_silentX=%d\n",
-			hostId,
-			ip,
-			port,
-			healthy,
-			fixedUser,
-			onlineUser,
-			onlineSeat,
-			onlineAnonym,
-			untreatedTask,
-			login,
-			logout,
-			loginUser,
-			logoutUser,
-			queryCalled,
-			queryCalledSuc,
-			queryCalledDHT,
-			relayMsg,
-			relayMsgCAHCESuc,
-			relayMsgQueryDHT,
-			relayMsgLocalSuc,
-			relaySeatMsg,
-			relayUserQueuePos,
-			pushAPNS,
-			pushSilent)
-		collectBuf.WriteString(mx)
-	}
-	return nil
+	regGauge("host_healthy", "p2p_host_heathy", host_healthy)
+	regGauge("host_fixedUser", "p2p_host_fixed_user", host_fixedUser)
+	regGauge("host_onlineUser", "p2p_host_online_user", host_onlineUser)
+	regGauge("host_onlineSeat", "p2p_host_online_seat", host_onlineSeat)
+	regGauge("host_onlineAnonym", "p2p_host_online_anonym", host_onlineAnonym)
+	regGauge("host_untreatedTask", "p2p_host_untreated_task", host_untreatedTask)
+	regGauge("host_login", "p2p_host_new_login", host_login)
+	regGauge("host_logout", "p2p_host_new_logout", host_logout)
+	regGauge("host_loginUser", "p2p_host_new_login_user", host_loginUser)
+	regGauge("host_logoutUser", "p2p_host_new_logout_user", host_logoutUser)
+	regGauge("host_queryCalled", "p2p_host_new_query_called", host_queryCalled)
+	regGauge("host_queryCalledSuc", "p2p_host_new_query_called_success", host_queryCalledSuc)
+	regGauge("host_queryCalledDHT", "p2p_host_new_query_called_DHT", host_queryCalledDHT)
+	regGauge("host_relayMsg", "p2p_host_new_relay_msg", host_relayMsg)
+	regGauge("host_relayMsgCAHCESuc", "p2p_host_new_relay_msg_CAHCE_success", host_relayMsgCAHCESuc)
+	regGauge("host_relayMsgQueryDHT", "p2p_host_new_relay_msg_query_DHT", host_relayMsgQueryDHT)
+	regGauge("host_relayMsgLocalSuc", "p2p_host_new_relay_msg_local_success", host_relayMsgLocalSuc)
+	regGauge("host_relaySeatMsg", "p2p_host_new_relay_seat_msg", host_relaySeatMsg)
+	regGauge("host_relayUserQueuePos", "p2p_host_new_relay_user_pos_msg", host_relayUserQueuePos)
+	regGauge("host_pushAPNS", "p2p_host_new_push_APNS", host_pushAPNS)
+	regGauge("host_pushSilent", "p2p_host_new_push_silent", host_pushSilent)
 }
 
 var ( //statistic.relay.action
@@ -940,65 +897,16 @@ var ( //statistic.relay.action
 )
 
 func regRelay() {
-	prometheus.MustRegister(relay_onphone)
-	prometheus.MustRegister(relay_onconnect)
-	prometheus.MustRegister(relay_shortLiveMsg)
-	prometheus.MustRegister(relay_buildingMsg)
-	prometheus.MustRegister(relay_media)
-	prometheus.MustRegister(relay_invalidMsg)
-	prometheus.MustRegister(relay_callBeg)
-	prometheus.MustRegister(relay_callEnd)
-	prometheus.MustRegister(relay_upStream)
-	prometheus.MustRegister(relay_downStream)
-}
-
-func extractRelay(ri []string) error {
-	//  时间 relay节点id relay IP relay Port *并发通话数 *接入|落地用户数 *最近3分钟短链保活消息数 *最近3分钟转发建路包数 *最近3分钟转发媒体包数 *最近3分钟无效消息数据 *最近3分钟通话建立次数 *最近3分钟通话结束次数 *最近3分钟平均媒体转发上行流量 *最近3分钟平均媒体转发下行流量
-	relayId := ri[1]
-	ip := ri[2]
-	port := ri[3]
-	onphone, _ := strconv.Atoi(ri[4])
-	onconnect, _ := strconv.Atoi(ri[5])
-	shortLiveMsg, _ := strconv.Atoi(ri[6])
-	buildingMsg, _ := strconv.Atoi(ri[7])
-	media, _ := strconv.Atoi(ri[8])
-	invalidMsg, _ := strconv.Atoi(ri[9])
-	callSetup, _ := strconv.Atoi(ri[10])
-	callEnd, _ := strconv.Atoi(ri[11])
-	upStream, _ := strconv.Atoi(ri[12])
-	downStream, _ := strconv.Atoi(ri[13])
-	if globeCfg.Output.Prometheus || globeCfg.Output.PushGateway {
-		relay_onphone.WithLabelValues(relayId, ip, port).Set(float64(onphone))
-		relay_onconnect.WithLabelValues(relayId, ip, port).Set(float64(onconnect))
-		relay_shortLiveMsg.WithLabelValues(relayId, ip, port).Set(float64(shortLiveMsg))
-		relay_buildingMsg.WithLabelValues(relayId, ip, port).Set(float64(buildingMsg))
-		relay_media.WithLabelValues(relayId, ip, port).Set(float64(media))
-		relay_invalidMsg.WithLabelValues(relayId, ip, port).Set(float64(invalidMsg))
-		relay_callBeg.WithLabelValues(relayId, ip, port).Set(float64(callSetup))
-		relay_callEnd.WithLabelValues(relayId, ip, port).Set(float64(callEnd))
-		relay_upStream.WithLabelValues(relayId, ip, port).Set(float64(upStream))
-		relay_downStream.WithLabelValues(relayId, ip, port).Set(float64(downStream))
-	}
-
-	if globeCfg.Output.Telegraf {
-		mx := fmt.Sprintf("p2p_relay,id=%s,addr=%s:%s onphone=%d,onconnect=%d,short_live_msgX=%d,building_msgX=%d,media_packetX=%d,invalid_msgX=%d,call_setupX=%d,call_endX=%d,up_streamX=%d,down_streamX=%d\n",
-			relayId,
-			ip,
-			port,
-			onphone,
-			onconnect,
-			shortLiveMsg,
-			buildingMsg,
-			media,
-			invalidMsg,
-			callSetup,
-			callEnd,
-			upStream,
-			downStream)
-		collectBuf.WriteString(mx)
-	}
-
-	return nil
+	regGauge("relay_onphone", "p2p_relay_onphone", relay_onphone)
+	regGauge("relay_onconnect", "p2p_relay_onconnect", relay_onconnect)
+	regGauge("relay_shortLiveMsg", "p2p_relay_new_short_living_msg", relay_shortLiveMsg)
+	regGauge("relay_buildingMsg", "p2p_relay_new_building_msg", relay_buildingMsg)
+	regGauge("relay_media", "p2p_relay_new_media_packet", relay_media)
+	regGauge("relay_invalidMsg", "p2p_relay_new_invalid_msg", relay_invalidMsg)
+	regGauge("relay_callBeg", "p2p_relay_new_call_setup", relay_callBeg)
+	regGauge("relay_callEnd", "p2p_relay_new_call_end", relay_callEnd)
+	regGauge("relay_upStream", "p2p_relay_new_up_stream", relay_upStream)
+	regGauge("relay_downStream", "p2p_relay_new_down_stream", relay_downStream)
 }
 
 var ( //statistic.bootstrap.action
@@ -1061,39 +969,10 @@ var ( //statistic.bootstrap.action
 )
 
 func regBootstrap() {
-	prometheus.MustRegister(bootstrap_query)
-	prometheus.MustRegister(bootstrap_heathyHost)
-	prometheus.MustRegister(bootstrap_Host)
-	prometheus.MustRegister(bootstrap_Route)
-}
-
-func extractBootstrap(ps []string) error {
-	//  时间 Bootstrap节点ID Bootstrap IP Bootstrap Port *3分钟查询次数 *当前健康HOST数 *当前HOST总数 *路由表长度
-	bootstrapId := ps[1]
-	ip := ps[2]
-	port := ps[3]
-	query, _ := strconv.Atoi(ps[4])
-	heathyHost, _ := strconv.Atoi(ps[5])
-	host, _ := strconv.Atoi(ps[6])
-	route, _ := strconv.Atoi(ps[7])
-	if globeCfg.Output.Prometheus || globeCfg.Output.PushGateway {
-		bootstrap_query.WithLabelValues(bootstrapId, ip, port).Set(float64(query))
-		bootstrap_heathyHost.WithLabelValues(bootstrapId, ip, port).Set(float64(heathyHost))
-		bootstrap_Host.WithLabelValues(bootstrapId, ip, port).Set(float64(host))
-		bootstrap_Route.WithLabelValues(bootstrapId, ip, port).Set(float64(route))
-	}
-	if globeCfg.Output.Telegraf {
-		mx := fmt.Sprintf("p2p_bootstrap,id=%s,addr=%s:%s queryX=%d,heathy_host=%d,host=%d,route_len=%d\n",
-			bootstrapId,
-			ip,
-			port,
-			query,
-			heathyHost,
-			host,
-			route)
-		collectBuf.WriteString(mx)
-	}
-	return nil
+	regGauge("bootstrap_query", "p2p_bootstrap_new_query", bootstrap_query)
+	regGauge("bootstrap_heathyHost", "p2p_bootstrap_heathy_host", bootstrap_heathyHost)
+	regGauge("bootstrap_host", "p2p_bootstrap_host", bootstrap_Host)
+	regGauge("bootstrap_route", "p2p_bootstrap_route_table_len", bootstrap_Route)
 }
 
 var ( //statistic.DHT.action
@@ -1238,65 +1117,15 @@ var ( //statistic.DHT.action
 )
 
 func regDHT() {
-	prometheus.MustRegister(dht_status)
-	prometheus.MustRegister(dht_heathy)
-	prometheus.MustRegister(dht_route)
-	prometheus.MustRegister(dht_online)
-	prometheus.MustRegister(dht_offline)
-	prometheus.MustRegister(dht_silent)
-	prometheus.MustRegister(dht_connect)
-	prometheus.MustRegister(dht_getvalue)
-	prometheus.MustRegister(dht_setvalue)
-}
-
-func extractDHT(ps []string) error {
-	//  时间 DHT节点id 所属Host节点ID DHT的KAD IP	 DHt的KAD Port *DHT连接状态 *DHT是否健康 *路由表个数 *在线信息用户数 *ANPS离线信息用户数 *有静默通道用户数 *Connect应用总数 Host列表 *最近3分钟内GetValue次数 *最近3分钟内SetValue次数 *GetValue响应速度列表
-	dhtId := ps[1]
-	hostId := ps[2]
-	ip := ps[3]
-	port := ps[4]
-	status, _ := strconv.Atoi(ps[5])
-	heathy, _ := strconv.Atoi(ps[6])
-	route, _ := strconv.Atoi(ps[7])
-	online, _ := strconv.Atoi(ps[8])
-	offline, _ := strconv.Atoi(ps[9])
-	silent, _ := strconv.Atoi(ps[10])
-	connect, _ := strconv.Atoi(ps[11])
-	getvalue, _ := strconv.Atoi(ps[13])
-	setvalue, _ := strconv.Atoi(ps[14])
-	if globeCfg.Output.Prometheus || globeCfg.Output.PushGateway {
-		dht_status.WithLabelValues(dhtId, hostId, ip, port).Set(float64(status))
-		dht_heathy.WithLabelValues(dhtId, hostId, ip, port).Set(float64(heathy))
-		dht_route.WithLabelValues(dhtId, hostId, ip, port).Set(float64(route))
-		dht_online.WithLabelValues(dhtId, hostId, ip, port).Set(float64(online))
-		dht_offline.WithLabelValues(dhtId, hostId, ip, port).Set(float64(offline))
-		dht_silent.WithLabelValues(dhtId, hostId, ip, port).Set(float64(silent))
-		dht_connect.WithLabelValues(dhtId, hostId, ip, port).Set(float64(connect))
-		//Host列表
-		dht_getvalue.WithLabelValues(dhtId, hostId, ip, port).Set(float64(getvalue))
-		dht_setvalue.WithLabelValues(dhtId, hostId, ip, port).Set(float64(setvalue))
-		//GetValue响应速度列表
-	}
-
-	if globeCfg.Output.Telegraf {
-		mx := fmt.Sprintf("p2p_dht,id=%s,hostId=%s,addr=%s:%s status=%d,heathy=%d,host=%d,route_table=%d,online=%d,offline=%d,silent=%d,connect=%d,getvalue=%d,setvalue=%d\n",
-			dhtId,
-			hostId,
-			ip,
-			port,
-			status,
-			heathy,
-			route,
-			online,
-			offline,
-			silent,
-			connect,
-			getvalue,
-			setvalue)
-		collectBuf.WriteString(mx)
-	}
-
-	return nil
+	regGauge("dht_status", "p2p_dht_status", dht_status)
+	regGauge("dht_heathy", "p2p_dht_heathy", dht_heathy)
+	regGauge("dht_route", "p2p_dht_route_table", dht_route)
+	regGauge("dht_online", "p2p_dht_online", dht_online)
+	regGauge("dht_offline", "p2p_dht_offline", dht_offline)
+	regGauge("dht_silent", "p2p_dht_silent", dht_silent)
+	regGauge("dht_connect", "p2p_dht_connect", dht_connect)
+	regGauge("dht_getvalue", "p2p_dht_getvalue", dht_getvalue)
+	regGauge("dht_setvalue", "p2p_dht_setvalue", dht_setvalue)
 }
 
 var ( //statistic.SPS.action
@@ -1393,54 +1222,15 @@ var ( //statistic.SPS.action
 )
 
 func regSPS() {
-	prometheus.MustRegister(sps_connect)
-	prometheus.MustRegister(sps_msg)
-	prometheus.MustRegister(sps_hostMsg)
-	prometheus.MustRegister(sps_clientMsg)
-	prometheus.MustRegister(sps_silentMsg)
-	prometheus.MustRegister(sps_silentMsgOk)
+	regGauge("sps_connect", "p2p_sps_connect", sps_connect)
+	regGauge("sps_msg", "p2p_sps_new_send_msg", sps_msg)
+	regGauge("sps_hostMsg", "p2p_sps_new_send_host_msg", sps_hostMsg)
+	regGauge("sps_clientMsg", "p2p_sps_new_send_client_msg", sps_clientMsg)
+	regGauge("sps_silentMsg", "p2p_sps_new_send_silent_msg", sps_silentMsg)
+	regGauge("sps_silentMsgOk", "p2p_sps_new_send_host_msg_ok", sps_silentMsgOk)
 
 }
 
-func extractSPS(ps []string) error {
-	//  时间 SPS节点id 所属Host节点ID SPS IP SPS Port *通道[信令双通道+静默通道]连接数 *最近3分钟发送消息总数 *最近3分钟双通道发送给Host消息数 8最近3分钟双通道给客发送客户端消息数 *最近3分钟静默通道推送次数 *最近3分钟静默通道推送成功次数
-	spsId := ps[1]
-	hostId := ps[2]
-	ip := ps[3]
-	port := ps[4]
-	connect, _ := strconv.Atoi(ps[5])
-	msg, _ := strconv.Atoi(ps[6])
-	hostMsg, _ := strconv.Atoi(ps[7])
-	clientMsg, _ := strconv.Atoi(ps[8])
-	silentMsg, _ := strconv.Atoi(ps[9])
-	silentMsgOk, _ := strconv.Atoi(ps[10])
-	if globeCfg.Output.Prometheus || globeCfg.Output.PushGateway {
-		sps_connect.WithLabelValues(spsId, hostId, ip, port).Set(float64(connect))
-		sps_msg.WithLabelValues(spsId, hostId, ip, port).Set(float64(msg))
-		sps_hostMsg.WithLabelValues(spsId, hostId, ip, port).Set(float64(hostMsg))
-		sps_clientMsg.WithLabelValues(spsId, hostId, ip, port).Set(float64(clientMsg))
-		sps_silentMsg.WithLabelValues(spsId, hostId, ip, port).Set(float64(silentMsg))
-		sps_silentMsgOk.WithLabelValues(spsId, hostId, ip, port).Set(float64(silentMsgOk))
-	}
-
-	if globeCfg.Output.Telegraf {
-		mx := fmt.Sprintf("p2p_sps,id=%s,hostId=%s,addr=%s:%s connect=%d,send_msgX=%d,send_host_msgX=%d,send_client_msgX=%d,send_silent_msgX=%d,send_silent_msg_okX=%d\n",
-			spsId,
-			hostId,
-			ip,
-			port,
-			connect,
-			msg,
-			hostMsg,
-			clientMsg,
-			silentMsg,
-			silentMsgOk)
-		collectBuf.WriteString(mx)
-	}
-
-	return nil
-}
-
 var ( //statistic.ANPS.action
 	//*与APNS连接成功通道数
 	anps_connect = prometheus.NewGaugeVec(
@@ -1520,46 +1310,11 @@ var ( //statistic.ANPS.action
 )
 
 func regANPS() {
-	prometheus.MustRegister(anps_connect)
-	prometheus.MustRegister(anps_task)
-	prometheus.MustRegister(anps_pushed)
-	prometheus.MustRegister(anps_pushSucced)
-	prometheus.MustRegister(anps_pushFailed)
-}
-
-func extractANPS(ps []string) error {
-	//  时间 PS节点id 所属Host节点ID PS IP PS Port *与APNS连接成功通道数 *待推送的任务数 *最近3分钟推送总数 *最近3分钟推送成功次数 *最近3分钟推送失败次数
-	anpsId := ps[1]
-	hostId := ps[2]
-	ip := ps[3]
-	port := ps[4]
-	connect, _ := strconv.Atoi(ps[5])
-	task, _ := strconv.Atoi(ps[6])
-	pushed, _ := strconv.Atoi(ps[7])
-	pushSucced, _ := strconv.Atoi(ps[8])
-	pushFailed, _ := strconv.Atoi(ps[9])
-	if globeCfg.Output.Prometheus || globeCfg.Output.PushGateway {
-		anps_connect.WithLabelValues(anpsId, hostId, ip, port).Set(float64(connect))
-		anps_task.WithLabelValues(anpsId, hostId, ip, port).Set(float64(task))
-		anps_pushed.WithLabelValues(anpsId, hostId, ip, port).Set(float64(pushed))
-		anps_pushSucced.WithLabelValues(anpsId, hostId, ip, port).Set(float64(pushSucced))
-		anps_pushFailed.WithLabelValues(anpsId, hostId, ip, port).Set(float64(pushFailed))
-	}
-
-	if globeCfg.Output.Telegraf {
-		mx := fmt.Sprintf("p2p_anps,id=%s,hostId=%s,addr=%s:%s connect=%d,task=%d,pushedX=%d,push_okX=%d,push_nokX=%d\n",
-			anpsId,
-			hostId,
-			ip,
-			port,
-			connect,
-			task,
-			pushed,
-			pushSucced,
-			pushFailed)
-		collectBuf.WriteString(mx)
-	}
-	return nil
+	regGauge("anps_connect", "p2p_apns_connect", anps_connect)
+	regGauge("anps_task", "p2p_apns_task", anps_task)
+	regGauge("anps_pushed", "p2p_apns_new_pushed", anps_pushed)
+	regGauge("anps_pushSucced", "p2p_apns_new_push_succed", anps_pushSucced)
+	regGauge("anps_pushFailed", "p2p_apns_new_push_failed", anps_pushFailed)
 }
 
 var ( //statistic.CM.action
@@ -1686,56 +1441,14 @@ var ( //statistic.CM.action
 )
 
 func regCM() {
-	prometheus.MustRegister(cm_onphone)
-	prometheus.MustRegister(cm_onphoneV)
-	prometheus.MustRegister(cm_onphoneA)
-	prometheus.MustRegister(cm_hangup)
-	prometheus.MustRegister(cm_broken)
-	prometheus.MustRegister(cm_blockBySys)
-	prometheus.MustRegister(cm_blockByOps)
-	prometheus.MustRegister(cm_blockOffline)
-}
-
-func extractCM(ps []string) error {
-	//  时间 CallMgr节点ID 所属Host节点ID CallMgr IP CallMgr port *当前通话数 *最近3分钟视频通数 *最近3分钟音频话数 *最近3分钟正常挂断通话数 *最近3分钟异常挂断通话数 *最近3分钟系统原因未接通数 *最近3分钟人为原因未接通数 *最近3分钟被叫不在线未接通数
-	cmId := ps[1]
-	hostId := ps[2]
-	ip := ps[3]
-	port := ps[4]
-	onphone, _ := strconv.Atoi(ps[5])
-	onphoneV, _ := strconv.Atoi(ps[6])
-	onphoneA, _ := strconv.Atoi(ps[7])
-	hangup, _ := strconv.Atoi(ps[8])
-	broken, _ := strconv.Atoi(ps[9])
-	blockBySys, _ := strconv.Atoi(ps[10])
-	blockByOps, _ := strconv.Atoi(ps[11])
-	blockOffline, _ := strconv.Atoi(ps[12])
-	cm_onphone.WithLabelValues(cmId, hostId, ip, port).Set(float64(onphone))
-	cm_onphoneV.WithLabelValues(cmId, hostId, ip, port).Set(float64(onphoneV))
-	cm_onphoneA.WithLabelValues(cmId, hostId, ip, port).Set(float64(onphoneA))
-	cm_hangup.WithLabelValues(cmId, hostId, ip, port).Set(float64(hangup))
-	cm_broken.WithLabelValues(cmId, hostId, ip, port).Set(float64(broken))
-	cm_blockBySys.WithLabelValues(cmId, hostId, ip, port).Set(float64(blockBySys))
-	cm_blockByOps.WithLabelValues(cmId, hostId, ip, port).Set(float64(blockByOps))
-	cm_blockOffline.WithLabelValues(cmId, hostId, ip, port).Set(float64(blockOffline))
-
-	if globeCfg.Output.Telegraf {
-		mx := fmt.Sprintf("p2p_cm,id=%s,hostId=%s,addr=%s:%s onphone=%d,onvideoX=%d,onaudioX=%d,releasedX=%d,brokenX=%d,sys_blockX=%d,ops_blockX=%d,offline_blockX=%d\n",
-			cmId,
-			hostId,
-			ip,
-			port,
-			onphone,
-			onphoneV,
-			onphoneA,
-			hangup,
-			broken,
-			blockBySys,
-			blockByOps,
-			blockOffline)
-		collectBuf.WriteString(mx)
-	}
-	return nil
+	regGauge("cm_onphone", "p2p_cm_onphone", cm_onphone)
+	regGauge("cm_onphoneV", "p2p_cm_new_onphone_video", cm_onphoneV)
+	regGauge("cm_onphoneA", "p2p_cm_new_onphone_audio", cm_onphoneA)
+	regGauge("cm_hangup", "p2p_cm_new_released", cm_hangup)
+	regGauge("cm_broken", "p2p_cm_new_broken", cm_broken)
+	regGauge("cm_blockBySys", "p2p_cm_new_block_by_sys", cm_blockBySys)
+	regGauge("cm_blockByOps", "p2p_cm_new_block_by_man", cm_blockByOps)
+	regGauge("cm_blockOffline", "p2p_cm_new_block_called_offline", cm_blockOffline)
 }
 
 var ( //test
@@ -1749,14 +1462,15 @@ var ( //test
 	)
 )
 
-var (
-	collectBuf bytes.Buffer
-	tcpConnect net.Conn
-)
-
 func init() {
+	if isCommand(os.Args) {
+		loadCmdCfg()
+		return
+	}
 	loadCfg()
-	if globeCfg.Output.Prometheus || globeCfg.Output.PushGateway {
+	// remoteWrite/otlp/graphite/statsd/zabbix也用这些Gauge的指标名
+	if globeCfg.Output.Prometheus || globeCfg.Output.PushGateway || globeCfg.RemoteWrite.Enable || globeCfg.Otlp.Enable ||
+		globeCfg.Graphite.Enable || globeCfg.Statsd.Enable || globeCfg.Zabbix.Enable {
 		regServerSummary()
 		regUserStatistic()
 		regCallStatistic()
@@ -1767,20 +1481,25 @@ func init() {
 		regSPS()
 		regANPS()
 		regCM()
+		regImpact()
+		regBaseline()
+		regAnomaly()
+		regCapacity()
+		regBalance()
+		regBandwidth()
+		regDhtRing()
+		regPush()
+		regFunnel()
+		regSla()
+		regSink()
+		regTelegraf()
+		regInflux()
+		regRemoteWrite()
+		regGraphite()
+		regStatsd()
+		regZabbix()
 		prometheus.MustRegister(call_vdn_err)
 	}
-
-	if globeCfg.Output.Telegraf {
-		ta := strings.Split(globeCfg.Output.TelegrafAddr, "://")
-		if len(ta) != 2 {
-			log.Fatal("invalid TelegrafAddr:", globeCfg.Output.TelegrafAddr)
-		}
-		var err error = nil
-		tcpConnect, err = net.Dial(ta[0], ta[1])
-		if err != nil {
-			log.Fatal("Dial TelegrafAddr:", err)
-		}
-	}
 }
 
 type VMDExtractor func([]string) error
@@ -1817,74 +1536,88 @@ func parseVdnMonitorData(data string, extractor VMDExtractor) error {
 }
 
 func main() {
+	if isCommand(os.Args) {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	if globeCfg.Output.Prometheus {
 		setupFakeServer()
 	}
 
-	if globeCfg.Output.Prometheus {
+	openTsdb()
+	openRollup()
+	openBaseline()
+	openAnomaly()
+	openBandwidth()
+	openSla()
+	openSinks()
+	exitCh := handleExit()
+
+	if globeCfg.Output.Prometheus || globeCfg.Gw.Api {
 		go func() {
-			http.Handle("/metrics", promhttp.Handler())
+			if globeCfg.Output.Prometheus {
+				http.Handle("/metrics", promhttp.Handler())
+			}
+			if globeCfg.Gw.Api {
+				regApi()
+			}
 			log.Fatal(http.ListenAndServe(fmt.Sprintf("%s:%d", globeCfg.Gw.Addr, globeCfg.Gw.HttpListenPort), nil))
 		}()
 	}
 
 	apis := []struct {
-		api       string
-		extractor VMDExtractor
+		action string
+		api    string
 	}{
 		//    获取数据的http端口
-		//			{api: "statistic.serverSummary.action", extractor: extractServerSummary},
-		//			{api: "statistic.userStatistic.action", extractor: extractUserStatistic},
-		//			{api: "statistic.callStatistic.action", extractor: extractCallStatistic},
+		//			{action: "serverSummary", api: "statistic.serverSummary.action"},
+		//			{action: "userStatistic", api: "statistic.userStatistic.action"},
+		//			{action: "callStatistic", api: "statistic.callStatistic.action"},
 		//			//			//TODO:statistic.acd.action
 		//			//			//TODO:statistic.im.action
-		//			{api: "statistic.host.action", extractor: extractHost},
-		//			{api: "statistic.relay.action", extractor: extractRelay},
-		//			{api: "statistic.bootstrap.action", extractor: extractBootstrap},
-		//			{api: "statistic.DHT.action", extractor: extractDHT},
-		//			{api: "statistic.SPS.action", extractor: extractSPS},
-		//			{api: "statistic.ANPS.action", extractor: extractANPS},
-		//			{api: "statistic.CM.action", extractor: extractCM},
+		//			{action: "host", api: "statistic.host.action"},
+		//			{action: "relay", api: "statistic.relay.action"},
+		//			{action: "bootstrap", api: "statistic.bootstrap.action"},
+		//			{action: "dht", api: "statistic.DHT.action"},
+		//			{action: "sps", api: "statistic.SPS.action"},
+		//			{action: "anps", api: "statistic.ANPS.action"},
+		//			{action: "cm", api: "statistic.CM.action"},
 		//			//TODO:statistic.rc.action
 		// 从本地文件获取数据
-		{api: globeCfg.Fileaddress.Server_sumary, extractor: extractServerSummary},
-		{api: globeCfg.Fileaddress.User_statistic, extractor: extractUserStatistic},
-		{api: globeCfg.Fileaddress.Call_statistic, extractor: extractCallStatistic},
-		{api: globeCfg.Fileaddress.Host_info, extractor: extractHost},
-		//{api: globeCfg.Fileaddress.Relay, extractor: extractRelay}
-		{api: globeCfg.Fileaddress.Bootstrap, extractor: extractBootstrap},
-		{api: globeCfg.Fileaddress.Dht, extractor: extractDHT},
-		{api: globeCfg.Fileaddress.Sps, extractor: extractSPS},
-		{api: globeCfg.Fileaddress.Ps, extractor: extractANPS},
-		{api: globeCfg.Fileaddress.Callmgr, extractor: extractCM},
+		{action: "serverSummary", api: globeCfg.Fileaddress.Server_sumary},
+		{action: "userStatistic", api: globeCfg.Fileaddress.User_statistic},
+		{action: "callStatistic", api: globeCfg.Fileaddress.Call_statistic},
+		{action: "host", api: globeCfg.Fileaddress.Host_info},
+		//{action: "relay", api: globeCfg.Fileaddress.Relay}
+		{action: "bootstrap", api: globeCfg.Fileaddress.Bootstrap},
+		{action: "dht", api: globeCfg.Fileaddress.Dht},
+		{action: "sps", api: globeCfg.Fileaddress.Sps},
+		{action: "anps", api: globeCfg.Fileaddress.Ps},
+		{action: "cm", api: globeCfg.Fileaddress.Callmgr},
 	}
 	for {
 
-		if err := relayfileToPrometheus(globeCfg.Fileaddress.Relay, extractRelay); err != nil {
+		relayCycle := newActionCycle("relay")
+		err := relayfileToPrometheus(globeCfg.Fileaddress.Relay, relayCycle.extractor(sampleExtractor("relay")))
+		lastCycle.commit(relayCycle, err)
+		if err != nil {
 			log.Println("fileToPrometheus:", err)
 			call_vdn_err.Inc()
-		} else {
-			if globeCfg.Output.Telegraf {
-				fmt.Fprintf(tcpConnect, collectBuf.String())
-				//fmt.Println(collectBuf.String())
-				collectBuf.Reset()
-			}
 		}
 		for _, api := range apis {
 			//				log.Println(api.api)
 			// 从本地文件获取数据
-			if err := fileToPrometheus(api.api, api.extractor); err != nil {
+			ac := newActionCycle(api.action)
+			err := fileToPrometheus(api.api, ac.extractor(sampleExtractor(api.action)))
+			lastCycle.commit(ac, err)
+			if err != nil {
 				log.Println("fileToPrometheus:", err)
 				call_vdn_err.Inc()
-			} else {
-				if globeCfg.Output.Telegraf {
-					fmt.Fprintf(tcpConnect, collectBuf.String())
-					//fmt.Println(collectBuf.String())
-					collectBuf.Reset()
-				}
 			}
 
 		}
+		sinks.flush()
+		afterCycle()
 
 		if globeCfg.Output.PushGateway {
 			// Push registry, all good.
@@ -1893,7 +1626,11 @@ func main() {
 			}
 		}
 
-		time.Sleep(time.Duration(globeCfg.Rest.Period) * time.Second)
+		select {
+		case sig := <-exitCh:
+			shutdown(sig)
+		case <-time.After(time.Duration(globeCfg.Rest.Period) * time.Second):
+		}
 	}
 
 }
@@ -1923,7 +1660,38 @@ func loadCfg() {
 	}
 	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
 
-	log.Println("cfg:", gwc)
+	log.Println("cfg:", redactCfg(gwc))
+}
+
+// redactCfg 日志里不输出密码/token/认证头
+func redactCfg(gwc GWConfig) GWConfig {
+	redact := func(s *string) {
+		if *s != "" {
+			*s = "***"
+		}
+	}
+	redact(&gwc.Influx.Password)
+	redact(&gwc.Influx.Token)
+	redact(&gwc.RemoteWrite.Password)
+	redact(&gwc.RemoteWrite.BearerToken)
+	if gwc.Otlp.Headers != nil {
+		headers := make(map[string]string, len(gwc.Otlp.Headers))
+		for k := range gwc.Otlp.Headers {
+			headers[k] = "***"
+		}
+		gwc.Otlp.Headers = headers
+	}
+	return gwc
+}
+
+// loadCmdCfg 子命令只用cfg.yaml里门户的地址, 没有cfg.yaml时默认localhost:9210, 可用--gw指定
+func loadCmdCfg() {
+	gwc := GWConfig{}
+	gwc.Gw.Addr, gwc.Gw.HttpListenPort = "localhost", 9210
+	if cfgbuf, err := ioutil.ReadFile("cfg.yaml"); err == nil {
+		yaml.Unmarshal(cfgbuf, &gwc)
+	}
+	globeCfg = &gwc
 }
 
 var fakeServer *httptest.Server = nil
@@ -0,0 +1,150 @@
+// otlp
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// otlpSink 把sample按OTLP导出给OpenTelemetry collector
+// 指标名与/metrics一致, 数据点属性与prometheus标签一致(NodeID HostID IP Port等), 时间取记录时间
+type otlpSink struct {
+	exporter sdkmetric.Exporter
+	resource *resource.Resource
+	scope    instrumentation.Scope
+	timeout  time.Duration
+	names    map[string]string
+}
+
+func newOtlpExporter(ctx context.Context) (sdkmetric.Exporter, error) {
+	cfg := &globeCfg.Otlp
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	switch cfg.Protocol {
+	case "", "http":
+		opts := []otlpmetrichttp.Option{
+			otlpmetrichttp.WithEndpoint(cfg.Endpoint),
+			otlpmetrichttp.WithHeaders(cfg.Headers),
+			otlpmetrichttp.WithTimeout(timeout),
+		}
+		if cfg.UrlPath != "" {
+			opts = append(opts, otlpmetrichttp.WithURLPath(cfg.UrlPath))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		}
+		if cfg.Gzip {
+			opts = append(opts, otlpmetrichttp.WithCompression(otlpmetrichttp.GzipCompression))
+		}
+		return otlpmetrichttp.New(ctx, opts...)
+	case "grpc":
+		opts := []otlpmetricgrpc.Option{
+			otlpmetricgrpc.WithEndpoint(cfg.Endpoint),
+			otlpmetricgrpc.WithHeaders(cfg.Headers),
+			otlpmetricgrpc.WithTimeout(timeout),
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlpmetricgrpc.WithInsecure())
+		}
+		if cfg.Gzip {
+			opts = append(opts, otlpmetricgrpc.WithCompressor("gzip"))
+		}
+		return otlpmetricgrpc.New(ctx, opts...)
+	}
+	return nil, fmt.Errorf("otlp: unsupported protocol %q", cfg.Protocol)
+}
+
+func newOtlpSink() (*otlpSink, error) {
+	cfg := &globeCfg.Otlp
+	if cfg.Endpoint == "" {
+		return nil, fmt.Errorf("otlp: endpoint required")
+	}
+	exp, err := newOtlpExporter(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	job := globeCfg.Output.JobName
+	if job == "" {
+		job = "p2p"
+	}
+	hostname, _ := os.Hostname()
+	attrs := []attribute.KeyValue{
+		attribute.String("service.name", job),
+		attribute.String("job", job),
+		attribute.String("host.name", hostname),
+	}
+	if cfg.Cluster != "" {
+		attrs = append(attrs, attribute.String("cluster", cfg.Cluster))
+	}
+	o := &otlpSink{
+		exporter: exp,
+		resource: resource.NewSchemaless(attrs...),
+		scope:    instrumentation.Scope{Name: "p2p-gateway"},
+		timeout:  time.Duration(cfg.Timeout) * time.Second,
+		names:    make(map[string]string),
+	}
+	if o.timeout <= 0 {
+		o.timeout = 10 * time.Second
+	}
+	return o, nil
+}
+
+func (o *otlpSink) Name() string { return "otlp" }
+
+// metrics 按指标名分组, 每个sample一个Gauge数据点
+func (o *otlpSink) metrics(ss []sample) []metricdata.Metrics {
+	byName := make(map[string]int)
+	var ms []metricdata.Metrics
+	for i := range ss {
+		s := &ss[i]
+		name, ok := o.names[s.metric()]
+		if !ok {
+			name = promName(s.metric())
+			o.names[s.metric()] = name
+		}
+		j, ok := byName[name]
+		if !ok {
+			j = len(ms)
+			byName[name] = j
+			ms = append(ms, metricdata.Metrics{Name: name, Data: metricdata.Gauge[float64]{}})
+		}
+		kvs := make([]attribute.KeyValue, len(s.Labels))
+		for k, l := range s.Labels {
+			kvs[k] = attribute.String(l.Name, l.Value)
+		}
+		g := ms[j].Data.(metricdata.Gauge[float64])
+		g.DataPoints = append(g.DataPoints, metricdata.DataPoint[float64]{
+			Attributes: attribute.NewSet(kvs...),
+			Time:       s.Time,
+			Value:      s.Value,
+		})
+		ms[j].Data = g
+	}
+	return ms
+}
+
+func (o *otlpSink) Write(ss []sample) error {
+	if len(ss) == 0 {
+		return nil
+	}
+	rm := &metricdata.ResourceMetrics{
+		Resource:     o.resource,
+		ScopeMetrics: []metricdata.ScopeMetrics{{Scope: o.scope, Metrics: o.metrics(ss)}},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
+	return o.exporter.Export(ctx, rm)
+}
@@ -0,0 +1,89 @@
+// otlp_test
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stvp/assert"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func otlpAttrs(kvs []*commonpb.KeyValue) map[string]string {
+	m := make(map[string]string)
+	for _, kv := range kvs {
+		m[kv.Key] = kv.Value.GetStringValue()
+	}
+	return m
+}
+
+func TestOtlpHTTP(t *testing.T) {
+	var req colmetricpb.ExportMetricsServiceRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/metrics", r.URL.Path, "")
+		assert.Equal(t, "abc", r.Header.Get("X-Token"), "")
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"), "")
+		zr, err := gzip.NewReader(r.Body)
+		assert.Nil(t, err, "")
+		body, _ := ioutil.ReadAll(zr)
+		assert.Nil(t, proto.Unmarshal(body, &req), "")
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(200)
+	}))
+	defer srv.Close()
+
+	saved, savedJob := globeCfg.Otlp, globeCfg.Output.JobName
+	defer func() { globeCfg.Otlp, globeCfg.Output.JobName = saved, savedJob }()
+	globeCfg.Otlp.Endpoint = strings.TrimPrefix(srv.URL, "http://")
+	globeCfg.Otlp.Protocol = "http"
+	globeCfg.Otlp.UrlPath = ""
+	globeCfg.Otlp.Insecure = true
+	globeCfg.Otlp.Gzip = true
+	globeCfg.Otlp.Headers = map[string]string{"X-Token": "abc"}
+	globeCfg.Otlp.Cluster = "bj"
+	globeCfg.Output.JobName = "p2p-gw"
+	o, err := newOtlpSink()
+	assert.Nil(t, err, "")
+
+	ss := cmSamples("1", "2")
+	assert.Nil(t, o.Write(ss), "")
+	assert.Equal(t, 1, len(req.ResourceMetrics), "")
+	rm := req.ResourceMetrics[0]
+	res := otlpAttrs(rm.Resource.Attributes)
+	assert.Equal(t, "bj", res["cluster"], "")
+	assert.Equal(t, "p2p-gw", res["job"], "")
+
+	ms := rm.ScopeMetrics[0].Metrics
+	var onphone int
+	for _, m := range ms {
+		if m.Name != promName("cm_onphone") {
+			continue
+		}
+		for _, dp := range m.GetGauge().DataPoints {
+			onphone++
+			attrs := otlpAttrs(dp.Attributes)
+			assert.True(t, attrs["CmId"] == "1" || attrs["CmId"] == "2", "")
+			assert.Equal(t, 10.0, dp.GetAsDouble(), "")
+			assert.Equal(t, uint64(ss[0].Time.UnixNano()), dp.TimeUnixNano, "")
+		}
+	}
+	assert.Equal(t, 2, onphone, "")
+}
+
+func TestOtlpConfig(t *testing.T) {
+	saved := globeCfg.Otlp
+	defer func() { globeCfg.Otlp = saved }()
+	globeCfg.Otlp.Endpoint = ""
+	_, err := newOtlpSink()
+	assert.NotNil(t, err, "")
+	globeCfg.Otlp.Endpoint = "127.0.0.1:4317"
+	globeCfg.Otlp.Protocol = "udp"
+	_, err = newOtlpSink()
+	assert.NotNil(t, err, "")
+}
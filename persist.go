@@ -0,0 +1,80 @@
+// persist
+package main
+
+import (
+	"encoding/gob"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// persistMu 退出时的落盘可能与周期内的落盘同时进行, 共用同一个临时文件
+var persistMu sync.Mutex
+
+// exitSavers 退出前落盘的函数, 由各openXxx登记
+var exitSavers []func()
+
+func onExit(save func()) {
+	exitSavers = append(exitSavers, save)
+}
+
+// handleExit 捕获SIGINT/SIGTERM, 主循环在当前周期结束后调用shutdown退出
+func handleExit() <-chan os.Signal {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+	return ch
+}
+
+// flushOnExit 写出各Sink队列中的数据(最多等sinkFlushWait)后落盘, 否则重启会丢失这些数据
+func flushOnExit() {
+	sinks.flush()
+	for _, save := range exitSavers {
+		save()
+	}
+}
+
+func shutdown(sig os.Signal) {
+	log.Println("exit:", sig)
+	flushOnExit()
+	os.Exit(0)
+}
+
+// saveGob 先写临时文件再改名, 避免写一半时进程退出损坏原文件
+func saveGob(file string, v interface{}) error {
+	persistMu.Lock()
+	defer persistMu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(v); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// loadGob 文件不存在时返回nil, v保持不变
+func loadGob(file string, v interface{}) error {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return gob.NewDecoder(f).Decode(v)
+}
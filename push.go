@@ -0,0 +1,335 @@
+// push
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var ( //push
+	push_forwardRatio = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "push",
+			Name:      "forward_ratio",
+			Help:      "pushes received by ANPS/SPS / pushes sent by host in the window.",
+		},
+		[]string{
+			"HostID",
+			"Channel",
+		},
+	)
+	push_successRatio = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "push",
+			Name:      "success_ratio",
+			Help:      "succeeded pushes / pushes received by ANPS/SPS in the window.",
+		},
+		[]string{
+			"HostID",
+			"Channel",
+		},
+	)
+	push_loss = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "push",
+			Name:      "loss",
+			Help:      "pushes sent by host but not succeeded in the window.",
+		},
+		[]string{
+			"HostID",
+			"Channel",
+		},
+	)
+	push_lossRatio = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "push",
+			Name:      "loss_ratio",
+			Help:      "loss / pushes sent by host in the window.",
+		},
+		[]string{
+			"HostID",
+			"Channel",
+		},
+	)
+	push_backlogGrowth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "push",
+			Name:      "backlog_growth",
+			Help:      "consecutive records in which anps task grew.",
+		},
+		[]string{
+			"ApnsId",
+			"HostID",
+		},
+	)
+)
+
+func regPush() {
+	prometheus.MustRegister(push_forwardRatio)
+	prometheus.MustRegister(push_successRatio)
+	prometheus.MustRegister(push_loss)
+	prometheus.MustRegister(push_lossRatio)
+	prometheus.MustRegister(push_backlogGrowth)
+}
+
+const (
+	pushAPNS   = "apns"
+	pushSilent = "silent"
+)
+
+// 一个Host在一个3分钟窗口内某个推送通道的对账结果
+type pushChannel struct {
+	Channel      string  `json:"channel"`
+	Sent         float64 `json:"sent"`     // Host pushAPNS/pushSilent
+	Received     float64 `json:"received"` // ANPS pushed / SPS silentMsg
+	Succeeded    float64 `json:"succeeded"`
+	Failed       float64 `json:"failed,omitempty"`
+	ForwardRatio float64 `json:"forwardRatio"`
+	SuccessRatio float64 `json:"successRatio"`
+	Loss         float64 `json:"loss"`
+	LossRatio    float64 `json:"lossRatio"`
+}
+
+func (pc *pushChannel) reconcile() {
+	if pc.Sent > 0 {
+		pc.ForwardRatio = pc.Received / pc.Sent
+	}
+	if pc.Received > 0 {
+		pc.SuccessRatio = pc.Succeeded / pc.Received
+	}
+	if pc.Loss = pc.Sent - pc.Succeeded; pc.Loss < 0 {
+		pc.Loss = 0
+	}
+	if pc.Sent > 0 {
+		pc.LossRatio = pc.Loss / pc.Sent
+	}
+}
+
+type pushBacklog struct {
+	ApnsID string  `json:"apnsId"`
+	HostID string  `json:"hostId"`
+	Task   float64 `json:"task"`
+	Growth int     `json:"growth"` // 连续增长的记录数
+}
+
+type pushHost struct {
+	HostID   string         `json:"hostId"`
+	Channels []*pushChannel `json:"channels"`
+	Backlogs []*pushBacklog `json:"backlogs"`
+}
+
+type pushReport struct {
+	Hosts     []*pushHost `json:"hosts"`
+	Unmatched []string    `json:"unmatched"` // 拓扑中找不到所属Host的ANPS/SPS, 不参与对账
+}
+
+// anps_task的走势, 按记录时间去重
+type taskTrend struct {
+	Last   int64
+	Task   float64
+	Growth int
+}
+
+var pushTrends = struct {
+	sync.Mutex
+	m map[string]*taskTrend
+}{m: make(map[string]*taskTrend)}
+
+func trackBacklog(apnsID string, t time.Time, task float64) int {
+	pushTrends.Lock()
+	defer pushTrends.Unlock()
+	tt, ok := pushTrends.m[apnsID]
+	if !ok {
+		pushTrends.m[apnsID] = &taskTrend{Last: t.Unix(), Task: task}
+		return 0
+	}
+	if t.Unix() <= tt.Last {
+		return tt.Growth
+	}
+	if task > tt.Task {
+		tt.Growth++
+	} else {
+		tt.Growth = 0
+	}
+	tt.Last, tt.Task = t.Unix(), task
+	return tt.Growth
+}
+
+func backlogGrowth(apnsID string) int {
+	pushTrends.Lock()
+	defer pushTrends.Unlock()
+	if tt, ok := pushTrends.m[apnsID]; ok {
+		return tt.Growth
+	}
+	return 0
+}
+
+func pushParams() (lossRatio float64, growth int) {
+	lossRatio = globeCfg.Push.LossRatio
+	if lossRatio <= 0 {
+		lossRatio = 0.05
+	}
+	growth = globeCfg.Push.BacklogGrowth
+	if growth <= 0 {
+		growth = 3
+	}
+	return
+}
+
+// buildPush 按Host对账, ANPS/SPS通过拓扑(hostId或同IP)归属到Host
+func buildPush() *pushReport {
+	tp := buildTopology()
+	pr := &pushReport{Hosts: []*pushHost{}, Unmatched: []string{}}
+	// hostOf 找不到所属Host时记入Unmatched, 否则会多出一个HostID为空的Host
+	hostOf := func(typ, id string) (string, bool) {
+		if n, ok := tp.byID[topoID(typ, id)]; ok && n.HostID != "" {
+			return n.HostID, true
+		}
+		pr.Unmatched = append(pr.Unmatched, topoID(typ, id))
+		return "", false
+	}
+
+	byHost := make(map[string]*pushHost)
+	host := func(id string) *pushHost {
+		ph, ok := byHost[id]
+		if !ok {
+			ph = &pushHost{
+				HostID:   id,
+				Channels: []*pushChannel{{Channel: pushAPNS}, {Channel: pushSilent}},
+				Backlogs: []*pushBacklog{},
+			}
+			byHost[id] = ph
+			pr.Hosts = append(pr.Hosts, ph)
+		}
+		return ph
+	}
+
+	hs := schemaOf("host")
+	for _, rec := range lastCycle.records("host") {
+		id := hs.label(rec, "HostID")
+		if id == "" {
+			continue
+		}
+		ph := host(id)
+		ph.Channels[0].Sent += hs.num(rec, "pushAPNS")
+		ph.Channels[1].Sent += hs.num(rec, "pushSilent")
+	}
+	as := schemaOf("anps")
+	for _, rec := range lastCycle.records("anps") {
+		id := as.label(rec, "ApnsId")
+		if id == "" {
+			continue
+		}
+		hostID, ok := hostOf("anps", id)
+		if !ok {
+			continue
+		}
+		ph := host(hostID)
+		apns := ph.Channels[0]
+		apns.Received += as.num(rec, "pushed")
+		apns.Succeeded += as.num(rec, "pushSucced")
+		apns.Failed += as.num(rec, "pushFailed")
+		ph.Backlogs = append(ph.Backlogs, &pushBacklog{
+			ApnsID: id,
+			HostID: ph.HostID,
+			Task:   as.num(rec, "task"),
+			Growth: backlogGrowth(id),
+		})
+	}
+	ss := schemaOf("sps")
+	for _, rec := range lastCycle.records("sps") {
+		id := ss.label(rec, "SpsId")
+		if id == "" {
+			continue
+		}
+		hostID, ok := hostOf("sps", id)
+		if !ok {
+			continue
+		}
+		silent := host(hostID).Channels[1]
+		silent.Received += ss.num(rec, "silentMsg")
+		silent.Succeeded += ss.num(rec, "silentMsgOk")
+	}
+
+	for _, ph := range pr.Hosts {
+		for _, pc := range ph.Channels {
+			pc.reconcile()
+		}
+	}
+	sort.Slice(pr.Hosts, func(i, j int) bool { return pr.Hosts[i].HostID < pr.Hosts[j].HostID })
+	return pr
+}
+
+func (pr *pushReport) events() []event {
+	maxLoss, maxGrowth := pushParams()
+	var evs []event
+	for _, ph := range pr.Hosts {
+		for _, pc := range ph.Channels {
+			if pc.Sent > 0 && pc.LossRatio > maxLoss {
+				evs = append(evs, event{
+					Key:      "host:" + ph.HostID + ":" + pc.Channel,
+					Severity: "critical",
+					Message:  fmt.Sprintf("host %s %s push loss above %.0f%%", ph.HostID, pc.Channel, maxLoss*100),
+					Nodes:    []string{topoID("host", ph.HostID)},
+				})
+			}
+		}
+		for _, b := range ph.Backlogs {
+			if b.Growth >= maxGrowth {
+				evs = append(evs, event{
+					Key:      "anps:" + b.ApnsID,
+					Severity: "warning",
+					Message:  fmt.Sprintf("anps %s task backlog growing", b.ApnsID),
+					Nodes:    []string{topoID("anps", b.ApnsID)},
+				})
+			}
+		}
+	}
+	return evs
+}
+
+func updatePush() {
+	as := schemaOf("anps")
+	for _, rec := range lastCycle.records("anps") {
+		if t, ok := recordTime(rec); ok && as.label(rec, "ApnsId") != "" {
+			trackBacklog(as.label(rec, "ApnsId"), t, as.num(rec, "task"))
+		}
+	}
+
+	pr := buildPush()
+	if globeCfg.Output.Prometheus || globeCfg.Output.PushGateway {
+		// 节点下线后不再出现, 每个周期重新设置
+		push_forwardRatio.Reset()
+		push_successRatio.Reset()
+		push_loss.Reset()
+		push_lossRatio.Reset()
+		push_backlogGrowth.Reset()
+		for _, ph := range pr.Hosts {
+			for _, pc := range ph.Channels {
+				push_forwardRatio.WithLabelValues(ph.HostID, pc.Channel).Set(pc.ForwardRatio)
+				push_successRatio.WithLabelValues(ph.HostID, pc.Channel).Set(pc.SuccessRatio)
+				push_loss.WithLabelValues(ph.HostID, pc.Channel).Set(pc.Loss)
+				push_lossRatio.WithLabelValues(ph.HostID, pc.Channel).Set(pc.LossRatio)
+			}
+			for _, b := range ph.Backlogs {
+				push_backlogGrowth.WithLabelValues(b.ApnsID, b.HostID).Set(float64(b.Growth))
+			}
+		}
+	}
+	events.update("push", pr.events())
+}
+
+// /api/v1/push 最近一个窗口各Host的推送对账
+func pushHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, buildPush())
+}
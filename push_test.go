@@ -0,0 +1,61 @@
+// push_test
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stvp/assert"
+)
+
+func TestBuildPush(t *testing.T) {
+	commitRecords("serverSummary")
+	commitRecords("host",
+		"2017.07.04 14:45:41.639|10000|103.25.23.75|11015|1|1000|700|0|0|0|0|0|0|0|0|0|0|0|0|0|0|0|0|100|40|1,2,3")
+	commitRecords("anps",
+		"2017.07.04 14:45:41.639|30001|0|103.25.23.75|10031|2|5|90|80|10")
+	commitRecords("sps",
+		"2017.07.04 14:45:41.639|50001|10000|103.25.23.76|10032|10|0|0|0|40|40",
+		"2017.07.04 14:45:41.639|50002|0|10.9.9.9|10032|10|0|0|0|40|40")
+	pr := buildPush()
+
+	// 找不到所属Host的SPS不参与对账
+	assert.Equal(t, 1, len(pr.Hosts), "")
+	assert.Equal(t, []string{"sps:50002"}, pr.Unmatched, "")
+	apns, silent := pr.Hosts[0].Channels[0], pr.Hosts[0].Channels[1]
+	assert.Equal(t, 0.9, apns.ForwardRatio, "")
+	assert.Equal(t, 20.0, apns.Loss, "")
+	assert.Equal(t, 0.2, apns.LossRatio, "")
+	assert.Equal(t, 0.0, silent.LossRatio, "")
+	assert.Equal(t, 1, len(pr.events()), "")
+
+	// 按schema的标签取节点ID, 列数不够时为空
+	as := schemaOf("anps")
+	assert.Equal(t, "30001", as.label(strings.Split("2017.07.04 14:45:41.639|30001|0|103.25.23.75", "|"), "ApnsId"), "")
+	assert.Equal(t, "", as.label([]string{"2017.07.04 14:45:41.639"}, "ApnsId"), "")
+
+	// 下线的ANPS不再导出
+	saved := globeCfg.Output
+	defer func() { globeCfg.Output = saved }()
+	globeCfg.Output.Prometheus = true
+	updatePush()
+	assert.Equal(t, 1, collectCount(push_backlogGrowth), "")
+	commitRecords("anps")
+	updatePush()
+	assert.Equal(t, 0, collectCount(push_backlogGrowth), "")
+}
+
+func TestTrackBacklog(t *testing.T) {
+	pushTrends.Lock()
+	delete(pushTrends.m, "test")
+	pushTrends.Unlock()
+	base := time.Date(2017, 7, 4, 14, 0, 0, 0, time.Local)
+	for i, task := range []float64{5, 8, 8, 9, 12, 20} {
+		trackBacklog("test", base.Add(time.Duration(i)*3*time.Minute), task)
+		trackBacklog("test", base.Add(time.Duration(i)*3*time.Minute), task)
+	}
+	assert.Equal(t, 3, backlogGrowth("test"), "")
+	trackBacklog("test", base.Add(time.Hour), 1)
+	assert.Equal(t, 0, backlogGrowth("test"), "")
+}
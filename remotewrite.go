@@ -0,0 +1,184 @@
+// remotewrite
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var ( //remote write
+	remoteWrite_dropped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "p2p",
+			Subsystem: "ops",
+			Name:      "remote_write_dropped_batches_total",
+			Help:      "batches dropped because the receiver rejected them or too many were unsent.",
+		},
+	)
+	remoteWrite_pending = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "ops",
+			Name:      "remote_write_pending_batches",
+			Help:      "batches waiting to be sent to the remote_write endpoint.",
+		},
+	)
+)
+
+func regRemoteWrite() {
+	prometheus.MustRegister(remoteWrite_dropped)
+	prometheus.MustRegister(remoteWrite_pending)
+}
+
+// promName 取sample对应Gauge的指标全名(与/metrics一致), 没有登记的用p2p_action_field
+func promName(metric string) string {
+	if name, ok := promNames[metric]; ok {
+		return name
+	}
+	return "p2p_" + metric
+}
+
+// protobuf编码, 只用到WriteRequest涉及的几种类型
+func pbVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func pbKey(b []byte, field, wire int) []byte {
+	return pbVarint(b, uint64(field<<3|wire))
+}
+
+func pbString(b []byte, field int, s string) []byte {
+	b = pbKey(b, field, 2)
+	b = pbVarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func pbMessage(b []byte, field int, msg []byte) []byte {
+	b = pbKey(b, field, 2)
+	b = pbVarint(b, uint64(len(msg)))
+	return append(b, msg...)
+}
+
+func pbDouble(b []byte, field int, v float64) []byte {
+	b = pbKey(b, field, 1)
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+	return append(b, buf[:]...)
+}
+
+func pbInt64(b []byte, field int, v int64) []byte {
+	b = pbKey(b, field, 0)
+	return pbVarint(b, uint64(v))
+}
+
+type rwSeries struct {
+	labels  []label
+	samples []sample
+}
+
+// encodeWriteRequest 按prometheus remote write 1.0的WriteRequest编码
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; } // 毫秒
+func encodeWriteRequest(series []*rwSeries) []byte {
+	var req, ts, msg []byte
+	for _, s := range series {
+		ts = ts[:0]
+		for _, l := range s.labels {
+			msg = pbString(msg[:0], 1, l.Name)
+			msg = pbString(msg, 2, l.Value)
+			ts = pbMessage(ts, 1, msg)
+		}
+		for _, smp := range s.samples {
+			msg = pbDouble(msg[:0], 1, smp.Value)
+			msg = pbInt64(msg, 2, smp.Time.UnixNano()/int64(1e6))
+			ts = pbMessage(ts, 2, msg)
+		}
+		req = pbMessage(req, 1, ts)
+	}
+	return req
+}
+
+// remoteWriteSink 作为remote_write客户端写Prometheus/VictoriaMetrics/Thanos receive, 时间戳取记录时间
+type remoteWriteSink struct {
+	*httpQueue
+	extra []label // job instance
+	names map[string]string
+}
+
+func newRemoteWriteSink() (*remoteWriteSink, error) {
+	cfg := &globeCfg.RemoteWrite
+	if cfg.Url == "" {
+		return nil, fmt.Errorf("remoteWrite: url required")
+	}
+	hq := newHTTPQueue(cfg.Url, cfg.Timeout, cfg.Retries, cfg.Retention)
+	hq.header.Set("Content-Type", "application/x-protobuf")
+	hq.header.Set("Content-Encoding", "snappy")
+	hq.header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	hq.header.Set("User-Agent", "p2p-gateway")
+	if cfg.BearerToken != "" {
+		hq.header.Set("Authorization", "Bearer "+cfg.BearerToken)
+	}
+	hq.user, hq.password = cfg.Username, cfg.Password
+	hq.dropped, hq.buffered = remoteWrite_dropped, remoteWrite_pending
+
+	job := globeCfg.Output.JobName
+	if job == "" {
+		job = "p2p"
+	}
+	instance, _ := os.Hostname()
+	return &remoteWriteSink{
+		httpQueue: hq,
+		extra:     []label{{Name: "instance", Value: instance}, {Name: "job", Value: job}},
+		names:     make(map[string]string),
+	}, nil
+}
+
+func (rs *remoteWriteSink) Name() string { return "remoteWrite" }
+
+// series 同一序列的sample合并, 标签和样本分别按名字和时间排序
+func (rs *remoteWriteSink) series(ss []sample) []*rwSeries {
+	byKey := make(map[string]*rwSeries)
+	var out []*rwSeries
+	for i := range ss {
+		s := &ss[i]
+		name, ok := rs.names[s.metric()]
+		if !ok {
+			name = promName(s.metric())
+			rs.names[s.metric()] = name
+		}
+		key := seriesKey(name, s.Labels)
+		sr, ok := byKey[key]
+		if !ok {
+			ls := append([]label{{Name: "__name__", Value: name}}, rs.extra...)
+			ls = sortLabels(append(ls, s.Labels...))
+			sr = &rwSeries{labels: ls}
+			byKey[key] = sr
+			out = append(out, sr)
+		}
+		sr.samples = append(sr.samples, *s)
+	}
+	for _, sr := range out {
+		sort.SliceStable(sr.samples, func(i, j int) bool { return sr.samples[i].Time.Before(sr.samples[j].Time) })
+	}
+	return out
+}
+
+func (rs *remoteWriteSink) Write(ss []sample) error {
+	if len(ss) == 0 {
+		return rs.push(nil)
+	}
+	return rs.push(snappy.Encode(nil, encodeWriteRequest(rs.series(ss))))
+}
@@ -0,0 +1,108 @@
+// remotewrite_test
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/stvp/assert"
+)
+
+type pbField struct {
+	num   int
+	value uint64
+	bytes []byte
+}
+
+// pbDecode 解出一层protobuf字段, 只支持varint/fixed64/length-delimited
+func pbDecode(t *testing.T, b []byte) []pbField {
+	var fs []pbField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		b = b[n:]
+		f := pbField{num: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			f.value, n = binary.Uvarint(b)
+			b = b[n:]
+		case 1:
+			f.value = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			f.bytes = b[n : n+int(l)]
+			b = b[n+int(l):]
+		default:
+			t.Fatal("unexpected wire type", key&7)
+		}
+		fs = append(fs, f)
+	}
+	return fs
+}
+
+func TestRemoteWrite(t *testing.T) {
+	var series [][]pbField
+	fail := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "snappy", r.Header.Get("Content-Encoding"), "")
+		assert.Equal(t, "Bearer abc", r.Header.Get("Authorization"), "")
+		if fail {
+			fail = false
+			w.WriteHeader(503)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		req, err := snappy.Decode(nil, body)
+		assert.Nil(t, err, "")
+		for _, f := range pbDecode(t, req) {
+			assert.Equal(t, 1, f.num, "")
+			series = append(series, pbDecode(t, f.bytes))
+		}
+		w.WriteHeader(204)
+	}))
+	defer srv.Close()
+
+	saved := globeCfg.RemoteWrite
+	defer func() { globeCfg.RemoteWrite = saved }()
+	globeCfg.RemoteWrite.Url = srv.URL
+	globeCfg.RemoteWrite.BearerToken = "abc"
+	globeCfg.RemoteWrite.Retries = 2
+	rs, err := newRemoteWriteSink()
+	assert.Nil(t, err, "")
+	rs.retryWait = 0
+
+	ss := cmSamples("1")
+	assert.Nil(t, rs.Write(ss), "")
+	assert.Equal(t, 8, len(series), "")
+
+	// 第一条序列: cm_onphone, 标签按名字排序, 时间戳为记录时间(毫秒)
+	labels := make(map[string]string)
+	var names []string
+	var samples []pbField
+	for _, f := range series[0] {
+		if f.num == 1 {
+			kv := pbDecode(t, f.bytes)
+			labels[string(kv[0].bytes)] = string(kv[1].bytes)
+			names = append(names, string(kv[0].bytes))
+		} else {
+			samples = append(samples, f)
+		}
+	}
+	assert.Equal(t, "p2p_userStatistic_new_login", promName("userStatistic_login"), "")
+	assert.Equal(t, promName("cm_onphone"), labels["__name__"], "")
+	assert.Equal(t, "1", labels["CmId"], "")
+	assert.Equal(t, "103.25.23.75", labels["IP"], "")
+	assert.Equal(t, "p2p", labels["job"], "")
+	for i := 1; i < len(names); i++ {
+		assert.True(t, names[i-1] < names[i], "")
+	}
+	assert.Equal(t, 1, len(samples), "")
+	smp := pbDecode(t, samples[0].bytes)
+	assert.Equal(t, 10.0, math.Float64frombits(smp[0].value), "")
+	assert.Equal(t, ss[0].Time.UnixNano()/1e6, int64(smp[1].value), "")
+}
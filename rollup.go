@@ -0,0 +1,281 @@
+// rollup
+package main
+
+import (
+	"encoding/csv"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	periodHour = "hour"
+	periodDay  = "day"
+)
+
+type rollupBucket struct {
+	Start int64 // unix秒, 小时/天(本地时间)的起点
+	Count int
+	Min   float64
+	Max   float64
+	Sum   float64
+}
+
+func (b *rollupBucket) add(v float64) {
+	if b.Count == 0 || v < b.Min {
+		b.Min = v
+	}
+	if b.Count == 0 || v > b.Max {
+		b.Max = v
+	}
+	b.Sum += v
+	b.Count++
+}
+
+func (b *rollupBucket) avg() float64 {
+	if b.Count == 0 {
+		return 0
+	}
+	return b.Sum / float64(b.Count)
+}
+
+type rollupSeries struct {
+	Metric string
+	Labels []label
+	Last   int64 // 最近一个sample的时间, 同一条记录重复出现时不重复累计
+	Hours  []rollupBucket
+	Days   []rollupBucket
+}
+
+type rollupStore struct {
+	mu     sync.RWMutex
+	Series map[string]*rollupSeries
+}
+
+func periodStart(t time.Time, period string) int64 {
+	t = t.Local()
+	if period == periodDay {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local).Unix()
+	}
+	return t.Truncate(time.Hour).Unix()
+}
+
+func addToBuckets(bs []rollupBucket, start int64, v float64, keepFrom int64) []rollupBucket {
+	if n := len(bs); n == 0 || bs[n-1].Start < start {
+		bs = append(bs, rollupBucket{Start: start})
+	}
+	for i := len(bs) - 1; i >= 0; i-- {
+		if bs[i].Start == start {
+			bs[i].add(v)
+			break
+		}
+	}
+	return dropBuckets(bs, keepFrom)
+}
+
+func dropBuckets(bs []rollupBucket, keepFrom int64) []rollupBucket {
+	i := 0
+	for i < len(bs) && bs[i].Start < keepFrom {
+		i++
+	}
+	return bs[i:]
+}
+
+func (rs *rollupStore) add(s sample, hourRetention, dayRetention time.Duration) {
+	key := seriesKey(s.metric(), s.Labels)
+	t := s.Time.Unix()
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	ser, ok := rs.Series[key]
+	if !ok {
+		ser = &rollupSeries{Metric: s.metric(), Labels: s.Labels}
+		rs.Series[key] = ser
+	}
+	if t <= ser.Last {
+		return
+	}
+	ser.Last = t
+	ser.Hours = addToBuckets(ser.Hours, periodStart(s.Time, periodHour), s.Value, s.Time.Add(-hourRetention).Unix())
+	ser.Days = addToBuckets(ser.Days, periodStart(s.Time, periodDay), s.Value, s.Time.Add(-dayRetention).Unix())
+}
+
+// expire 去掉所有序列的过期桶, 下线的节点不再有sample, 桶都过期后删除该序列
+func (rs *rollupStore) expire(now time.Time, hourRetention, dayRetention time.Duration) {
+	hourFrom, dayFrom := now.Add(-hourRetention).Unix(), now.Add(-dayRetention).Unix()
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for key, ser := range rs.Series {
+		ser.Hours = dropBuckets(ser.Hours, hourFrom)
+		ser.Days = dropBuckets(ser.Days, dayFrom)
+		if len(ser.Hours) == 0 && len(ser.Days) == 0 {
+			delete(rs.Series, key)
+		}
+	}
+}
+
+type rollupResult struct {
+	Metric  string            `json:"metric"`
+	Labels  map[string]string `json:"labels"`
+	Buckets []rollupRow       `json:"buckets"`
+}
+
+type rollupRow struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Avg   float64   `json:"avg"`
+	Sum   float64   `json:"sum"`
+}
+
+func (rs *rollupStore) query(metric string, match []label, period string, from, to int64) []rollupResult {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	res := []rollupResult{}
+	for _, ser := range rs.Series {
+		if ser.Metric != metric || !matchLabels(ser.Labels, match) {
+			continue
+		}
+		bs := ser.Hours
+		if period == periodDay {
+			bs = ser.Days
+		}
+		r := rollupResult{Metric: ser.Metric, Labels: labelMap(ser.Labels), Buckets: []rollupRow{}}
+		for _, b := range bs {
+			if b.Start >= from && b.Start <= to {
+				r.Buckets = append(r.Buckets, rollupRow{
+					Start: time.Unix(b.Start, 0),
+					Count: b.Count,
+					Min:   b.Min,
+					Max:   b.Max,
+					Avg:   b.avg(),
+					Sum:   b.Sum,
+				})
+			}
+		}
+		res = append(res, r)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return seriesKey(metric, sortedLabels(res[i].Labels)) < seriesKey(metric, sortedLabels(res[j].Labels))
+	})
+	return res
+}
+
+var (
+	rollups     *rollupStore
+	rollupsSave time.Time
+)
+
+func rollupRetention() (hour, day time.Duration) {
+	hour = time.Duration(globeCfg.Rollup.HourRetention) * 24 * time.Hour
+	if hour <= 0 {
+		hour = 90 * 24 * time.Hour
+	}
+	day = time.Duration(globeCfg.Rollup.DayRetention) * 24 * time.Hour
+	if day <= 0 {
+		day = 730 * 24 * time.Hour
+	}
+	return
+}
+
+func openRollup() {
+	if !globeCfg.Rollup.Enable {
+		return
+	}
+	rollups = &rollupStore{Series: make(map[string]*rollupSeries)}
+	if err := loadGob(globeCfg.Rollup.Path, rollups); err != nil {
+		log.Println("openRollup:", err)
+		rollups = &rollupStore{Series: make(map[string]*rollupSeries)}
+	}
+	rollupsSave = time.Now()
+	onExit(saveRollup)
+}
+
+func updateRollup(ss []sample) {
+	if rollups == nil {
+		return
+	}
+	hour, day := rollupRetention()
+	for _, s := range ss {
+		rollups.add(s, hour, day)
+	}
+
+	if time.Since(rollupsSave) < time.Duration(globeCfg.Rollup.Flush)*time.Second {
+		return
+	}
+	rollups.expire(time.Now(), hour, day)
+	saveRollup()
+	rollupsSave = time.Now()
+}
+
+func saveRollup() {
+	rollups.mu.RLock()
+	err := saveGob(globeCfg.Rollup.Path, rollups)
+	rollups.mu.RUnlock()
+	if err != nil {
+		log.Println("saveRollup:", err)
+	}
+}
+
+func writeRollupCSV(w http.ResponseWriter, res []rollupResult) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"metric", "labels", "start", "count", "min", "max", "avg", "sum"})
+	ff := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	for _, r := range res {
+		ls := seriesKey("", sortedLabels(r.Labels))
+		for _, b := range r.Buckets {
+			cw.Write([]string{r.Metric, ls, b.Start.Format(time.RFC3339), strconv.Itoa(b.Count), ff(b.Min), ff(b.Max), ff(b.Avg), ff(b.Sum)})
+		}
+	}
+	cw.Flush()
+}
+
+// /api/v1/rollup?metric=&labels=&period=hour|day&from=&to=&format=csv
+func rollupHandler(w http.ResponseWriter, r *http.Request) {
+	if rollups == nil {
+		http.Error(w, "rollup disabled", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	metric := q.Get("metric")
+	if metric == "" {
+		http.Error(w, "metric required", http.StatusBadRequest)
+		return
+	}
+	period := q.Get("period")
+	if period == "" {
+		period = periodHour
+	}
+	if period != periodHour && period != periodDay {
+		http.Error(w, "invalid period", http.StatusBadRequest)
+		return
+	}
+	match, err := parseLabelMatch(q.Get("labels"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	to, err := parseAPIEnd(q.Get("to"), now)
+	if err != nil {
+		http.Error(w, "invalid to", http.StatusBadRequest)
+		return
+	}
+	from, err := parseAPITime(q.Get("from"), to.AddDate(0, 0, -7))
+	if err != nil {
+		http.Error(w, "invalid from", http.StatusBadRequest)
+		return
+	}
+	// 只给日期时to为次日0点, 不含次日的桶
+	res := rollups.query(metric, match, period, from.Unix(), to.Unix()-1)
+	if q.Get("format") == "csv" {
+		writeRollupCSV(w, res)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,73 @@
+// rollup_test
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stvp/assert"
+)
+
+func TestRollup(t *testing.T) {
+	rs := &rollupStore{Series: make(map[string]*rollupSeries)}
+	ls := []label{{"RelayId", "14"}}
+	base := time.Date(2017, 7, 4, 23, 0, 0, 0, time.Local)
+	for i, v := range []float64{91, 95, 60, 120, 10} {
+		s := sample{Action: "relay", Field: "onphone", Labels: ls, Value: v, Time: base.Add(time.Duration(i) * 20 * time.Minute)}
+		rs.add(s, 24*time.Hour, 30*24*time.Hour)
+		rs.add(s, 24*time.Hour, 30*24*time.Hour)
+	}
+
+	hours := rs.query("relay_onphone", nil, periodHour, 0, base.Add(48*time.Hour).Unix())
+	assert.Equal(t, 1, len(hours), "")
+	assert.Equal(t, 2, len(hours[0].Buckets), "")
+	assert.Equal(t, 3, hours[0].Buckets[0].Count, "")
+	assert.Equal(t, 60.0, hours[0].Buckets[0].Min, "")
+	assert.Equal(t, 95.0, hours[0].Buckets[0].Max, "")
+	assert.Equal(t, 246.0, hours[0].Buckets[0].Sum, "")
+
+	days := rs.query("relay_onphone", ls, periodDay, 0, base.Add(48*time.Hour).Unix())
+	assert.Equal(t, 2, len(days[0].Buckets), "")
+	assert.Equal(t, 120.0, days[0].Buckets[1].Max, "")
+	assert.Equal(t, 65.0, days[0].Buckets[1].Avg, "")
+}
+
+func TestRollupExpire(t *testing.T) {
+	rs := &rollupStore{Series: make(map[string]*rollupSeries)}
+	base := time.Date(2017, 7, 4, 23, 0, 0, 0, time.Local)
+	rs.add(sample{Action: "relay", Field: "onphone", Labels: []label{{"RelayId", "14"}}, Value: 1, Time: base}, 24*time.Hour, 30*24*time.Hour)
+	rs.add(sample{Action: "relay", Field: "onphone", Labels: []label{{"RelayId", "15"}}, Value: 1, Time: base.Add(10 * 24 * time.Hour)}, 24*time.Hour, 30*24*time.Hour)
+
+	// 14只剩天桶
+	rs.expire(base.Add(10*24*time.Hour), 24*time.Hour, 30*24*time.Hour)
+	assert.Equal(t, 2, len(rs.Series), "")
+	assert.Equal(t, 0, len(rs.Series[seriesKey("relay_onphone", []label{{"RelayId", "14"}})].Hours), "")
+
+	// 14下线超过天桶的保留期后删除
+	rs.expire(base.Add(35*24*time.Hour), 24*time.Hour, 30*24*time.Hour)
+	assert.Equal(t, 1, len(rs.Series), "")
+	_, ok := rs.Series[seriesKey("relay_onphone", []label{{"RelayId", "15"}})]
+	assert.True(t, ok, "")
+}
+
+func TestRollupHandlerDates(t *testing.T) {
+	saved := rollups
+	defer func() { rollups = saved }()
+	rollups = &rollupStore{Series: make(map[string]*rollupSeries)}
+	base := time.Date(2017, 7, 4, 23, 0, 0, 0, time.Local)
+	for i := 0; i < 3; i++ {
+		s := sample{Action: "relay", Field: "onphone", Labels: []label{{"RelayId", "14"}}, Value: 10, Time: base.Add(time.Duration(i) * 40 * time.Minute)}
+		rollups.add(s, 24*time.Hour, 30*24*time.Hour)
+	}
+
+	// to只给日期时包含这一天, 不含次日
+	w := httptest.NewRecorder()
+	rollupHandler(w, httptest.NewRequest("GET", "/api/v1/rollup?metric=relay_onphone&period=day&from=2017-07-01&to=2017-07-04", nil))
+	var res []rollupResult
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res), "")
+	assert.Equal(t, 1, len(res), "")
+	assert.Equal(t, 1, len(res[0].Buckets), "")
+	assert.Equal(t, 2, res[0].Buckets[0].Count, "")
+}
@@ -0,0 +1,139 @@
+// sample
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type label struct {
+	Name  string
+	Value string
+}
+
+// 从记录中提取出的一个数值
+type sample struct {
+	Action string
+	Field  string
+	Labels []label // 按Name排序
+	Value  float64
+	Window bool // 最近3分钟的统计量
+	Time   time.Time
+}
+
+func (s *sample) metric() string {
+	return s.Action + "_" + s.Field
+}
+
+func (s *sample) label(name string) string {
+	for _, l := range s.Labels {
+		if l.Name == name {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+// scalar 列表项(分类终端等)与Telegraf一样不输出, NaN/Inf也不输出
+func (s *sample) scalar() bool {
+	return s.label("category") == "" && !math.IsNaN(s.Value) && !math.IsInf(s.Value, 0)
+}
+
+func sortLabels(ls []label) []label {
+	sort.Slice(ls, func(i, j int) bool { return ls[i].Name < ls[j].Name })
+	return ls
+}
+
+// seriesKey metric{a="1",b="2"}
+func seriesKey(metric string, ls []label) string {
+	var b strings.Builder
+	b.WriteString(metric)
+	b.WriteByte('{')
+	for i, l := range ls {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l.Name)
+		b.WriteString("=")
+		b.WriteString(strconv.Quote(l.Value))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// nodeLabelNames 基线/异常等跨action的Gauge统一用这些标签
+var nodeLabelNames = []string{"Metric", "NodeID", "SvcType", "HostID", "IP", "Port", "Category"}
+
+// nodeLabelValues NodeID取schema中的第一个标签(CmId RelayId等), HostId统一为HostID, 没有的为空
+func nodeLabelValues(s *sample) []string {
+	var id string
+	for _, f := range schemaOf(s.Action).Fields {
+		if f.Label != "" {
+			id = s.label(f.Label)
+			break
+		}
+	}
+	host := s.label("HostID")
+	if host == "" {
+		host = s.label("HostId")
+	}
+	return []string{s.metric(), id, s.label("SvcType"), host, s.label("IP"), s.label("Port"), s.label("category")}
+}
+
+// recordSamples 按schema把一条记录转为sample, 时间取记录时间
+// 与原来的各extractor一样, 解析不了的数值为0, 列表缺少的项也为0, 这样Gauge不会停在上个周期的值
+func recordSamples(as *actionSchema, rec []string, collected time.Time) []sample {
+	t, ok := recordTime(rec)
+	if !ok {
+		t = collected
+	}
+	var ls []label
+	for i, f := range as.Fields {
+		if f.Label != "" && i < len(rec) {
+			ls = append(ls, label{Name: f.Label, Value: rec[i]})
+		}
+	}
+	sortLabels(ls)
+
+	var ss []sample
+	for i := range as.Fields {
+		f := &as.Fields[i]
+		if i >= len(rec) {
+			break
+		}
+		switch {
+		case f.Kind == kindInt:
+			v, _ := strconv.ParseFloat(strings.TrimSpace(rec[i]), 64)
+			ss = append(ss, sample{Action: as.Action, Field: f.Name, Labels: ls, Value: v, Window: f.window(), Time: t})
+		case f.Kind == kindList && len(f.Items) > 0:
+			vs := parseIntList(rec[i])
+			for j, item := range f.Items {
+				var v int64
+				if j < len(vs) {
+					v = vs[j]
+				}
+				ils := sortLabels(append(append([]label{}, ls...), label{Name: "category", Value: item}))
+				ss = append(ss, sample{Action: as.Action, Field: f.Name, Labels: ils, Value: float64(v), Time: t})
+			}
+		}
+	}
+	return ss
+}
+
+// cycleSamples 最近一个周期所有action的sample
+func cycleSamples() []sample {
+	var ss []sample
+	for _, as := range actionSchemas {
+		ac := lastCycle.get(as.Action)
+		if ac == nil {
+			continue
+		}
+		for _, rec := range ac.Records {
+			ss = append(ss, recordSamples(as, rec, ac.Collected)...)
+		}
+	}
+	return ss
+}
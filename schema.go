@@ -0,0 +1,250 @@
+// schema
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+const (
+	kindTime   = "time"
+	kindString = "string"
+	kindInt    = "int"
+	kindList   = "list" // [1,2,3] 或 1,2,3
+	kindJSON   = "json"
+)
+
+// 记录中的一列, Desc取自VDN接口的desc, Name为英文别名
+type schemaField struct {
+	Desc  string   `json:"desc"`
+	Name  string   `json:"name"`
+	Kind  string   `json:"type"`
+	Label string   `json:"label,omitempty"` // 节点标识列对应的prometheus标签名
+	Items []string `json:"items,omitempty"` // 列表各项的名称
+}
+
+// window 是否为"最近3分钟"的统计量
+func (f *schemaField) window() bool {
+	return strings.Contains(f.Desc, "3分钟") || strings.Contains(f.Desc, "三分钟")
+}
+
+type actionSchema struct {
+	Action string
+	Vdn    string // VDN接口名
+	Fields []schemaField
+}
+
+// 分类终端/在线用户设备分布列表的各项
+var deviceCategories = []string{"X1", "N7/N8", "IOS", "Android", "WEB_GW", "PC", "AGENT", "PSTN_GW", "LINUX", "CLOUD_GW"}
+
+var actionSchemas = []*actionSchema{
+	{Action: "serverSummary", Vdn: "statistic.serverSummary.action", Fields: []schemaField{
+		{Desc: "时间", Name: "time", Kind: kindTime},
+		{Desc: "节点ID", Name: "nodeId", Kind: kindString, Label: "NodeID"},
+		{Desc: "服务器类型", Name: "svcType", Kind: kindString, Label: "SvcType"},
+		{Desc: "IP", Name: "ip", Kind: kindString, Label: "IP"},
+		{Desc: "port", Name: "port", Kind: kindString, Label: "Port"},
+		{Desc: "所属hostID,只有host子服务有效，其他服务为空", Name: "hostId", Kind: kindString, Label: "HostID"},
+		{Desc: "是否发布:1表示发布，0表示未发布", Name: "published", Kind: kindInt},
+		{Desc: "是否健康:1表示健康，0表示不健康", Name: "healthy", Kind: kindInt},
+	}},
+	{Action: "userStatistic", Vdn: "statistic.userStatistic.action", Fields: []schemaField{
+		{Desc: "时间", Name: "time", Kind: kindTime},
+		{Desc: "在线用户数", Name: "online", Kind: kindInt},
+		{Desc: "匿名用户数", Name: "anonym", Kind: kindInt},
+		{Desc: "可激活用户数", Name: "activable", Kind: kindInt},
+		{Desc: "最近三分钟登录用户数", Name: "login", Kind: kindInt},
+		{Desc: "最近三分钟登出用户数", Name: "logout", Kind: kindInt},
+		{Desc: "分类终端在线用户数", Name: "dcategory", Kind: kindList, Items: deviceCategories},
+	}},
+	{Action: "callStatistic", Vdn: "statistic.callStatistic.action", Fields: []schemaField{
+		{Desc: "时间", Name: "time", Kind: kindTime},
+		{Desc: "当前通话并发总数", Name: "onphone", Kind: kindInt},
+		{Desc: "当前视频通话总数", Name: "onphoneV", Kind: kindInt},
+		{Desc: "当前音频通话总数", Name: "onphoneA", Kind: kindInt},
+		{Desc: "最近3分钟通话量", Name: "callTraffic", Kind: kindInt},
+		{Desc: "最近3分钟未接通数", Name: "blockedCall", Kind: kindInt},
+		{Desc: "最近3分钟正常挂断数", Name: "releasedCall", Kind: kindInt},
+		{Desc: "最近3分钟异常挂断数", Name: "breakedCall", Kind: kindInt},
+	}},
+	{Action: "host", Vdn: "statistic.host.action", Fields: []schemaField{
+		{Desc: "时间", Name: "time", Kind: kindTime},
+		{Desc: "Host节点ID", Name: "hostId", Kind: kindString, Label: "HostID"},
+		{Desc: "Host IP", Name: "ip", Kind: kindString, Label: "IP"},
+		{Desc: "Host Port", Name: "port", Kind: kindString, Label: "Port"},
+		{Desc: "Host是否健康", Name: "healthy", Kind: kindInt},
+		{Desc: "额定用户数", Name: "fixedUser", Kind: kindInt},
+		{Desc: "在线用户数", Name: "onlineUser", Kind: kindInt},
+		{Desc: "坐席在线个数", Name: "onlineSeat", Kind: kindInt},
+		{Desc: "匿名在线用户数", Name: "onlineAnonym", Kind: kindInt},
+		{Desc: "工作线程未处理任务数", Name: "untreatedTask", Kind: kindInt},
+		{Desc: "最近3分钟登录次数", Name: "login", Kind: kindInt},
+		{Desc: "最近3分钟登出次数", Name: "logout", Kind: kindInt},
+		{Desc: "最近3分钟登录用户数", Name: "loginUser", Kind: kindInt},
+		{Desc: "最近3分钟登出用户数", Name: "logoutUser", Kind: kindInt},
+		{Desc: "最近3分钟查询被叫次数", Name: "queryCalled", Kind: kindInt},
+		{Desc: "最近3分钟查询被叫本地命中次数", Name: "queryCalledSuc", Kind: kindInt},
+		{Desc: "最近3分钟查询被叫DHT查询次数", Name: "queryCalledDHT", Kind: kindInt},
+		{Desc: "最近3分钟转发消息次数", Name: "relayMsg", Kind: kindInt},
+		{Desc: "最近3分钟转发消息CAHCE命中次数", Name: "relayMsgCAHCESuc", Kind: kindInt},
+		{Desc: "最近3分钟转发消息DHT查询次数", Name: "relayMsgQueryDHT", Kind: kindInt},
+		{Desc: "最近3分钟转发消息本地命中次数", Name: "relayMsgLocalSuc", Kind: kindInt},
+		{Desc: "最近3分钟发送坐席状态消息次数", Name: "relaySeatMsg", Kind: kindInt},
+		{Desc: "最近3分钟发送用户排队位置消息次数", Name: "relayUserQueuePos", Kind: kindInt},
+		{Desc: "最近3分钟向APNS通道推送次数", Name: "pushAPNS", Kind: kindInt},
+		{Desc: "最近3分钟向静默通道推送次数", Name: "pushSilent", Kind: kindInt},
+		{Desc: "在线用户设备分布列表", Name: "devices", Kind: kindList, Items: deviceCategories},
+	}},
+	{Action: "relay", Vdn: "statistic.relay.action", Fields: []schemaField{
+		{Desc: "时间", Name: "time", Kind: kindTime},
+		{Desc: "relay节点id", Name: "relayId", Kind: kindString, Label: "RelayId"},
+		{Desc: "relay IP", Name: "ip", Kind: kindString, Label: "IP"},
+		{Desc: "relay Port", Name: "port", Kind: kindString, Label: "Port"},
+		{Desc: "并发通话数", Name: "onphone", Kind: kindInt},
+		{Desc: "接入|落地用户数", Name: "onconnect", Kind: kindInt},
+		{Desc: "最近3分钟短链保活消息数", Name: "shortLiveMsg", Kind: kindInt},
+		{Desc: "最近3分钟转发建路包数", Name: "buildingMsg", Kind: kindInt},
+		{Desc: "最近3分钟转发媒体包数", Name: "media", Kind: kindInt},
+		{Desc: "最近3分钟无效消息数据", Name: "invalidMsg", Kind: kindInt},
+		{Desc: "最近3分钟通话建立次数", Name: "callBeg", Kind: kindInt},
+		{Desc: "最近3分钟通话结束次数", Name: "callEnd", Kind: kindInt},
+		{Desc: "最近3分钟平均媒体转发上行流量", Name: "upStream", Kind: kindInt},
+		{Desc: "最近3分钟平均媒体转发下行流量", Name: "downStream", Kind: kindInt},
+	}},
+	{Action: "bootstrap", Vdn: "statistic.bootstrap.action", Fields: []schemaField{
+		{Desc: "时间", Name: "time", Kind: kindTime},
+		{Desc: "Bootstrap节点ID", Name: "bootstrapId", Kind: kindString, Label: "BootstrapId"},
+		{Desc: "Bootstrap IP", Name: "ip", Kind: kindString, Label: "IP"},
+		{Desc: "Bootstrap Port", Name: "port", Kind: kindString, Label: "Port"},
+		{Desc: "3分钟查询次数", Name: "query", Kind: kindInt},
+		{Desc: "当前健康HOST数", Name: "heathyHost", Kind: kindInt},
+		{Desc: "当前HOST总数", Name: "host", Kind: kindInt},
+		{Desc: "路由表长度", Name: "route", Kind: kindInt},
+	}},
+	{Action: "dht", Vdn: "statistic.DHT.action", Fields: []schemaField{
+		{Desc: "时间", Name: "time", Kind: kindTime},
+		{Desc: "DHT节点id", Name: "dhtId", Kind: kindString, Label: "DhtId"},
+		{Desc: "所属Host节点ID", Name: "hostId", Kind: kindString, Label: "HostId"},
+		{Desc: "DHT的KAD IP\t", Name: "ip", Kind: kindString, Label: "IP"},
+		{Desc: "DHt的KAD Port", Name: "port", Kind: kindString, Label: "Port"},
+		{Desc: "DHT连接状态", Name: "status", Kind: kindInt},
+		{Desc: "DHT是否健康", Name: "heathy", Kind: kindInt},
+		{Desc: "路由表个数", Name: "route", Kind: kindInt},
+		{Desc: "在线信息用户数", Name: "online", Kind: kindInt},
+		{Desc: "ANPS离线信息用户数", Name: "offline", Kind: kindInt},
+		{Desc: "有静默通道用户数", Name: "silent", Kind: kindInt},
+		{Desc: "Connect应用总数", Name: "connect", Kind: kindInt},
+		{Desc: "Host列表", Name: "hosts", Kind: kindJSON},
+		{Desc: "最近3分钟内GetValue次数", Name: "getvalue", Kind: kindInt},
+		{Desc: "最近3分钟内SetValue次数", Name: "setvalue", Kind: kindInt},
+		{Desc: "GetValue响应速度列表", Name: "getvalueSpeed", Kind: kindList},
+	}},
+	{Action: "sps", Vdn: "statistic.SPS.action", Fields: []schemaField{
+		{Desc: "时间", Name: "time", Kind: kindTime},
+		{Desc: "SPS节点id", Name: "spsId", Kind: kindString, Label: "SpsId"},
+		{Desc: "所属Host节点ID", Name: "hostId", Kind: kindString, Label: "HostId"},
+		{Desc: "SPS IP", Name: "ip", Kind: kindString, Label: "IP"},
+		{Desc: "SPS Port", Name: "port", Kind: kindString, Label: "Port"},
+		{Desc: "通道[信令双通道+静默通道]连接数", Name: "connect", Kind: kindInt},
+		{Desc: "最近3分钟发送消息总数", Name: "msg", Kind: kindInt},
+		{Desc: "最近3分钟双通道发送给Host消息数", Name: "hostMsg", Kind: kindInt},
+		{Desc: "最近3分钟双通道给客发送客户端消息数", Name: "clientMsg", Kind: kindInt},
+		{Desc: "最近3分钟静默通道推送次数", Name: "silentMsg", Kind: kindInt},
+		{Desc: "最近3分钟静默通道推送成功次数", Name: "silentMsgOk", Kind: kindInt},
+	}},
+	{Action: "anps", Vdn: "statistic.ANPS.action", Fields: []schemaField{
+		{Desc: "时间", Name: "time", Kind: kindTime},
+		{Desc: "PS节点id", Name: "anpsId", Kind: kindString, Label: "ApnsId"},
+		{Desc: "所属Host节点ID", Name: "hostId", Kind: kindString, Label: "HostId"},
+		{Desc: "PS IP", Name: "ip", Kind: kindString, Label: "IP"},
+		{Desc: "PS Port", Name: "port", Kind: kindString, Label: "Port"},
+		{Desc: "与APNS连接成功通道数", Name: "connect", Kind: kindInt},
+		{Desc: "待推送的任务数", Name: "task", Kind: kindInt},
+		{Desc: "最近3分钟推送总数", Name: "pushed", Kind: kindInt},
+		{Desc: "最近3分钟推送成功次数", Name: "pushSucced", Kind: kindInt},
+		{Desc: "最近3分钟推送失败次数", Name: "pushFailed", Kind: kindInt},
+	}},
+	{Action: "cm", Vdn: "statistic.CM.action", Fields: []schemaField{
+		{Desc: "时间", Name: "time", Kind: kindTime},
+		{Desc: "CallMgr节点ID", Name: "cmId", Kind: kindString, Label: "CmId"},
+		{Desc: "所属Host节点ID", Name: "hostId", Kind: kindString, Label: "HostId"},
+		{Desc: "CallMgr IP", Name: "ip", Kind: kindString, Label: "IP"},
+		{Desc: "CallMgr port", Name: "port", Kind: kindString, Label: "Port"},
+		{Desc: "当前通话数", Name: "onphone", Kind: kindInt},
+		{Desc: "最近3分钟视频通数", Name: "onphoneV", Kind: kindInt},
+		{Desc: "最近3分钟音频话数", Name: "onphoneA", Kind: kindInt},
+		{Desc: "最近3分钟正常挂断通话数", Name: "hangup", Kind: kindInt},
+		{Desc: "最近3分钟异常挂断通话数", Name: "broken", Kind: kindInt},
+		{Desc: "最近3分钟系统原因未接通数", Name: "blockBySys", Kind: kindInt},
+		{Desc: "最近3分钟人为原因未接通数", Name: "blockByOps", Kind: kindInt},
+		{Desc: "最近3分钟被叫不在线未接通数", Name: "blockOffline", Kind: kindInt},
+	}},
+}
+
+// schemaOf 按action名或VDN接口名查找
+func schemaOf(action string) *actionSchema {
+	for _, as := range actionSchemas {
+		if as.Action == action || as.Vdn == action {
+			return as
+		}
+	}
+	return nil
+}
+
+// label 按标签名(HostID ApnsId等)取记录中的节点标识, 没有该列时为空
+func (as *actionSchema) label(rec []string, name string) string {
+	for i := range as.Fields {
+		if as.Fields[i].Label == name && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+	}
+	return ""
+}
+
+// num 按字段名取记录中的数值, 没有该字段或解析失败时为0
+func (as *actionSchema) num(rec []string, name string) float64 {
+	for i := range as.Fields {
+		if as.Fields[i].Name == name && i < len(rec) {
+			v, _ := strconv.ParseFloat(strings.TrimSpace(rec[i]), 64)
+			return v
+		}
+	}
+	return 0
+}
+
+// typedValue 按列类型转换, 解析失败时保留原字符串
+func typedValue(f *schemaField, v string) interface{} {
+	switch f.Kind {
+	case kindTime:
+		if t, err := recordTimeParse(v); err == nil {
+			return t
+		}
+	case kindInt:
+		if n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+			return n
+		}
+	case kindList:
+		return parseIntList(v)
+	case kindJSON:
+		var j interface{}
+		if err := json.Unmarshal([]byte(v), &j); err == nil {
+			return j
+		}
+	}
+	return v
+}
+
+// parseIntList [463,115,50] 或 115,3,0
+func parseIntList(v string) []int64 {
+	v = strings.Trim(strings.TrimSpace(v), "[]")
+	ns := []int64{}
+	if v == "" {
+		return ns
+	}
+	for _, s := range strings.Split(v, ",") {
+		n, _ := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		ns = append(ns, n)
+	}
+	return ns
+}
@@ -0,0 +1,290 @@
+// sink
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var ( //sink
+	sink_errors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "p2p",
+			Subsystem: "ops",
+			Name:      "sink_errors_total",
+			Help:      "sink write errors.",
+		},
+		[]string{
+			"sink",
+		},
+	)
+	sink_dropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "p2p",
+			Subsystem: "ops",
+			Name:      "sink_dropped_total",
+			Help:      "samples dropped because the sink queue is full.",
+		},
+		[]string{
+			"sink",
+		},
+	)
+)
+
+func regSink() {
+	prometheus.MustRegister(sink_errors)
+	prometheus.MustRegister(sink_dropped)
+}
+
+// Sink 输出端, 由dispatcher按批调用Write, 同一个Sink的Write不会并发
+type Sink interface {
+	Name() string
+	Write(ss []sample) error
+}
+
+type sinkConfig struct {
+	Queue int `yaml:"queue"` // 队列长度, 满了丢弃
+	Batch int `yaml:"batch"` // 每次Write最多的sample数
+	Flush int `yaml:"flush"` // 秒, 不满一批时的写出间隔
+}
+
+func sinkParams(name string) (queue, batch int, flush time.Duration) {
+	sc := globeCfg.Sinks[name]
+	queue, batch, flush = sc.Queue, sc.Batch, time.Duration(sc.Flush)*time.Second
+	if queue <= 0 {
+		queue = 10000
+	}
+	if batch <= 0 {
+		batch = 500
+	}
+	if flush <= 0 {
+		flush = time.Second
+	}
+	return
+}
+
+// 每个Sink一个队列和写出协程, 慢的Sink不影响其他Sink
+type sinkQueue struct {
+	sink  Sink
+	ch    chan sample
+	batch int
+	flush time.Duration
+	sync  chan chan struct{}
+}
+
+func (q *sinkQueue) write(buf []sample) {
+	for len(buf) > 0 {
+		n := q.batch
+		if n > len(buf) {
+			n = len(buf)
+		}
+		if err := q.sink.Write(buf[:n]); err != nil {
+			log.Println("sink", q.sink.Name()+":", err)
+			sink_errors.WithLabelValues(q.sink.Name()).Inc()
+		}
+		buf = buf[n:]
+	}
+}
+
+func (q *sinkQueue) run() {
+	tk := time.NewTicker(q.flush)
+	defer tk.Stop()
+	var buf []sample
+	for {
+		select {
+		case s := <-q.ch:
+			buf = append(buf, s)
+			if len(buf) >= q.batch {
+				q.write(buf)
+				buf = nil
+			}
+		case <-tk.C:
+			q.write(buf)
+			buf = nil
+		case done := <-q.sync:
+			for n := len(q.ch); n > 0; n-- {
+				buf = append(buf, <-q.ch)
+			}
+			q.write(buf)
+			buf = nil
+			close(done)
+		}
+	}
+}
+
+type dispatcher struct {
+	queues []*sinkQueue
+	wait   time.Duration // flush最多等待的时间, 默认sinkFlushWait
+}
+
+// 周期结束时等各Sink写完的最长时间, 对端不可达时重试很慢, 不能拖住整个周期
+const sinkFlushWait = 5 * time.Second
+
+var sinks = &dispatcher{}
+
+func (d *dispatcher) add(s Sink) {
+	queue, batch, flush := sinkParams(s.Name())
+	q := &sinkQueue{
+		sink:  s,
+		ch:    make(chan sample, queue),
+		batch: batch,
+		flush: flush,
+		sync:  make(chan chan struct{}, 1),
+	}
+	d.queues = append(d.queues, q)
+	go q.run()
+}
+
+// emit 分发到每个Sink的队列, 不阻塞, 队列满时丢弃并计数
+func (d *dispatcher) emit(ss []sample) {
+	for _, q := range d.queues {
+		for _, s := range ss {
+			select {
+			case q.ch <- s:
+			default:
+				sink_dropped.WithLabelValues(q.sink.Name()).Inc()
+			}
+		}
+	}
+}
+
+// flush 等待各Sink写出已分发的sample, 周期结束时调用
+// 最多等wait, 没写完的Sink由其协程继续写; 上次的flush还没处理完的Sink不再等
+func (d *dispatcher) flush() {
+	wait := d.wait
+	if wait <= 0 {
+		wait = sinkFlushWait
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	defer cancel()
+
+	dones := make([]chan struct{}, len(d.queues))
+	for i, q := range d.queues {
+		done := make(chan struct{})
+		select {
+		case q.sync <- done:
+			dones[i] = done
+		default:
+			log.Println("sink", q.sink.Name()+": still writing, not waited")
+		}
+	}
+	for i, done := range dones {
+		if done == nil {
+			continue
+		}
+		select {
+		case <-done:
+		case <-ctx.Done():
+			log.Println("sink", d.queues[i].sink.Name()+": flush timeout")
+		}
+	}
+}
+
+// sampleExtractor 按schema把记录转为sample发给各Sink
+func sampleExtractor(action string) VMDExtractor {
+	as := schemaOf(action)
+	return func(infos []string) error {
+		sinks.emit(recordSamples(as, infos, time.Now()))
+		return nil
+	}
+}
+
+func openSinks() {
+	if globeCfg.Output.Prometheus || globeCfg.Output.PushGateway {
+		sinks.add(promSink{})
+	}
+	if globeCfg.Output.Telegraf {
+		ts, err := newTelegrafSink(globeCfg.Output.TelegrafAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sinks.add(ts)
+	}
+	if globeCfg.Influx.Enable {
+		is, err := newInfluxSink()
+		if err != nil {
+			log.Fatal(err)
+		}
+		sinks.add(is)
+	}
+	if globeCfg.RemoteWrite.Enable {
+		rs, err := newRemoteWriteSink()
+		if err != nil {
+			log.Fatal(err)
+		}
+		sinks.add(rs)
+	}
+	if globeCfg.Otlp.Enable {
+		o, err := newOtlpSink()
+		if err != nil {
+			log.Fatal(err)
+		}
+		sinks.add(o)
+	}
+	if globeCfg.Graphite.Enable {
+		gs, err := newGraphiteSink()
+		if err != nil {
+			log.Fatal(err)
+		}
+		sinks.add(gs)
+	}
+	if globeCfg.Statsd.Enable {
+		sd, err := newStatsdSink()
+		if err != nil {
+			log.Fatal(err)
+		}
+		sinks.add(sd)
+	}
+	if globeCfg.Zabbix.Enable {
+		zs, err := newZabbixSink()
+		if err != nil {
+			log.Fatal(err)
+		}
+		sinks.add(zs)
+	}
+}
+
+// action_field -> GaugeVec/Gauge
+var promGauges = make(map[string]prometheus.Collector)
+
+// action_field -> 指标全名, 如host_onlineUser -> p2p_host_online_user
+var promNames = make(map[string]string)
+
+// regGauge 注册并按sample的metric名登记, 供prometheus sink查找; fqName与GaugeOpts的Namespace_Subsystem_Name一致
+func regGauge(metric, fqName string, c prometheus.Collector) {
+	prometheus.MustRegister(c)
+	promGauges[metric] = c
+	promNames[metric] = fqName
+}
+
+// promSink 把sample设置到已注册的Gauge上, /metrics和PushGateway共用
+type promSink struct{}
+
+func (promSink) Name() string { return "prometheus" }
+
+func (promSink) Write(ss []sample) error {
+	var err error
+	for i := range ss {
+		s := &ss[i]
+		switch g := promGauges[s.metric()].(type) {
+		case *prometheus.GaugeVec:
+			ls := make(prometheus.Labels, len(s.Labels))
+			for _, l := range s.Labels {
+				ls[l.Name] = l.Value
+			}
+			m, e := g.GetMetricWith(ls)
+			if e != nil {
+				err = fmt.Errorf("%s: %v", s.metric(), e)
+				continue
+			}
+			m.Set(s.Value)
+		case prometheus.Gauge:
+			g.Set(s.Value)
+		}
+	}
+	return err
+}
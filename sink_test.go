@@ -0,0 +1,113 @@
+// sink_test
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stvp/assert"
+)
+
+type fakeSink struct {
+	name    string
+	fail    bool
+	delay   time.Duration
+	mu      sync.Mutex
+	batches [][]sample
+}
+
+func (fs *fakeSink) Name() string { return fs.name }
+
+func (fs *fakeSink) Write(ss []sample) error {
+	time.Sleep(fs.delay)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.batches = append(fs.batches, append([]sample{}, ss...))
+	if fs.fail {
+		return errors.New("write failed")
+	}
+	return nil
+}
+
+func TestDispatcher(t *testing.T) {
+	saved := globeCfg.Sinks
+	defer func() { globeCfg.Sinks = saved }()
+	globeCfg.Sinks = map[string]sinkConfig{
+		"small": {Batch: 2, Flush: 3600},
+		"bad":   {Flush: 3600},
+	}
+
+	small, bad := &fakeSink{name: "small"}, &fakeSink{name: "bad", fail: true}
+	d := &dispatcher{}
+	d.add(small)
+	d.add(bad)
+
+	var ss []sample
+	for i := 0; i < 5; i++ {
+		ss = append(ss, sample{Action: "relay", Field: "onphone", Value: float64(i)})
+	}
+	d.emit(ss)
+	d.flush()
+
+	assert.Equal(t, 3, len(small.batches), "")
+	assert.Equal(t, 2, len(small.batches[0]), "")
+	assert.Equal(t, 1, len(small.batches[2]), "")
+	assert.Equal(t, 4.0, small.batches[2][0].Value, "")
+	// 出错的sink不影响其他sink
+	assert.Equal(t, 1, len(bad.batches), "")
+	assert.Equal(t, 5, len(bad.batches[0]), "")
+}
+
+func TestDispatcherSlowSink(t *testing.T) {
+	saved := globeCfg.Sinks
+	defer func() { globeCfg.Sinks = saved }()
+	globeCfg.Sinks = map[string]sinkConfig{
+		"fast": {Flush: 3600},
+		"slow": {Flush: 3600},
+	}
+
+	fast, slow := &fakeSink{name: "fast"}, &fakeSink{name: "slow", delay: time.Second}
+	d := &dispatcher{wait: 100 * time.Millisecond}
+	d.add(slow)
+	d.add(fast)
+
+	ss := []sample{{Action: "relay", Field: "onphone", Value: 1}}
+	// 慢的Sink不拖住周期, 也不影响其他Sink
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		d.emit(ss)
+		d.flush()
+		assert.True(t, time.Since(start) < 500*time.Millisecond, "")
+		fast.mu.Lock()
+		assert.Equal(t, i+1, len(fast.batches), "")
+		fast.mu.Unlock()
+	}
+}
+
+func TestTelegrafLines(t *testing.T) {
+	now := time.Now()
+	var ss []sample
+	ss = append(ss, recordSamples(schemaOf("cm"), strings.Split("2017.07.04 14:45:41.639|1|10000|103.25.23.75|8000|10|3|7|20|1|2|3|4", "|"), now)...)
+	ss = append(ss, recordSamples(schemaOf("userStatistic"), strings.Split("2017.07.04 14:45:41.639|100|5|20|7|6|[1,2,3]", "|"), now)...)
+
+	rt, _ := time.ParseInLocation(recordTimeLayout, "2017.07.04 14:45:41.639", time.Local)
+	ts := " " + strconv.FormatInt(rt.UnixNano(), 10)
+
+	lines := strings.Split(strings.TrimSpace(string(telegrafLines(ss))), "\n")
+	assert.Equal(t, 2, len(lines), "")
+	assert.Equal(t, "p2p_cm,addr=103.25.23.75:8000,hostId=10000,id=1 onphone=10i,onvideoX=3i,onaudioX=7i,releasedX=20i,brokenX=1i,sys_blockX=2i,ops_blockX=3i,offline_blockX=4i"+ts, lines[0], "")
+	assert.Equal(t, "p2p_userStatistic,host=all online=100i,anonym=5i,activable=20i,loginX=7i,logoutX=6i"+ts, lines[1], "")
+}
+
+func TestPromSink(t *testing.T) {
+	rec := strings.Split("2017.07.04 14:45:41.639|14|223.111.205.85|9000|120|300|1|2|3|4|5|6|7|8", "|")
+	ss := recordSamples(schemaOf("relay"), rec, time.Now())
+	assert.Nil(t, promSink{}.Write(ss), "")
+
+	ss[0].Labels = []label{{Name: "RelayId", Value: "14"}}
+	assert.NotNil(t, promSink{}.Write(ss[:1]), "")
+}
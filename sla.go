@@ -0,0 +1,384 @@
+// sla
+package main
+
+import (
+	"encoding/csv"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var ( //sla
+	sla_availability = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "sla",
+			Name:      "availability",
+			Help:      "healthy time / observed time of the node over the period.",
+		},
+		[]string{
+			"Node",
+			"Type",
+			"Period",
+		},
+	)
+)
+
+func regSla() {
+	prometheus.MustRegister(sla_availability)
+}
+
+// 时间段, unix秒
+type slaSpan struct {
+	Start int64
+	End   int64
+}
+
+type slaNode struct {
+	Type   string
+	NodeID string
+	IP     string
+	Port   string
+	Last   int64
+	Seen   []slaSpan // 有数据的时间段, 数据中断超过maxGap时不计入
+	Down   []slaSpan // 不健康的时间段, 结束于恢复健康的时刻
+	DownOn bool      // 最后一个Down仍在持续
+}
+
+type slaStore struct {
+	mu    sync.RWMutex
+	Nodes map[string]*slaNode
+}
+
+// extend 延长最后一段或新开一段
+func extend(ss []slaSpan, t int64, maxGap int64) []slaSpan {
+	if n := len(ss); n > 0 && t-ss[n-1].End <= maxGap {
+		ss[n-1].End = t
+		return ss
+	}
+	return append(ss, slaSpan{Start: t, End: t})
+}
+
+func prune(ss []slaSpan, keepFrom int64) []slaSpan {
+	i := 0
+	for i < len(ss) && ss[i].End < keepFrom {
+		i++
+	}
+	return ss[i:]
+}
+
+func (st *slaStore) observe(typ, nodeID, ip, port string, t time.Time, healthy bool, maxGap time.Duration, keepFrom int64) {
+	key := topoID(typ, nodeID)
+	gap := int64(maxGap / time.Second)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	n, ok := st.Nodes[key]
+	if !ok {
+		n = &slaNode{Type: typ, NodeID: nodeID}
+		st.Nodes[key] = n
+	}
+	n.IP, n.Port = ip, port
+	ts := t.Unix()
+	if ts <= n.Last {
+		return
+	}
+	// 数据中断后持续的故障在中断处结束
+	if n.DownOn && ts-n.Last > gap {
+		n.DownOn = false
+	}
+	n.Last = ts
+	n.Seen = prune(extend(n.Seen, ts, gap), keepFrom)
+	switch {
+	case !healthy && n.DownOn:
+		n.Down[len(n.Down)-1].End = ts
+	case !healthy:
+		n.Down = append(n.Down, slaSpan{Start: ts, End: ts})
+		n.DownOn = true
+	case n.DownOn:
+		n.Down[len(n.Down)-1].End = ts
+		n.DownOn = false
+	}
+	n.Down = prune(n.Down, keepFrom)
+}
+
+func overlap(ss []slaSpan, from, to int64) (sum int64, parts []slaSpan) {
+	for _, s := range ss {
+		a, b := s.Start, s.End
+		if a < from {
+			a = from
+		}
+		if b > to {
+			b = to
+		}
+		if b >= a {
+			sum += b - a
+			parts = append(parts, slaSpan{Start: a, End: b})
+		}
+	}
+	return
+}
+
+type slaDowntime struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+type slaNodeReport struct {
+	ID           string        `json:"id"`
+	Type         string        `json:"type"`
+	NodeID       string        `json:"nodeId"`
+	IP           string        `json:"ip"`
+	Port         string        `json:"port"`
+	Observed     int64         `json:"observed"` // 秒
+	Downtime     int64         `json:"downtime"` // 秒
+	Availability float64       `json:"availability"`
+	Downtimes    []slaDowntime `json:"downtimes"`
+}
+
+type slaTypeReport struct {
+	Type         string  `json:"type"`
+	Nodes        int     `json:"nodes"`
+	Observed     int64   `json:"observed"`
+	Downtime     int64   `json:"downtime"`
+	Availability float64 `json:"availability"`
+}
+
+type slaReport struct {
+	From  time.Time        `json:"from"`
+	To    time.Time        `json:"to"`
+	Types []*slaTypeReport `json:"types"`
+	Nodes []*slaNodeReport `json:"nodes"`
+}
+
+func availability(observed, downtime int64) float64 {
+	if observed <= 0 {
+		return 1
+	}
+	return 1 - float64(downtime)/float64(observed)
+}
+
+func (st *slaStore) report(from, to time.Time) *slaReport {
+	sr := &slaReport{From: from, To: to, Types: []*slaTypeReport{}, Nodes: []*slaNodeReport{}}
+	byType := make(map[string]*slaTypeReport)
+	st.mu.RLock()
+	for key, n := range st.Nodes {
+		observed, _ := overlap(n.Seen, from.Unix(), to.Unix())
+		if observed == 0 {
+			continue
+		}
+		downtime, parts := overlap(n.Down, from.Unix(), to.Unix())
+		nr := &slaNodeReport{
+			ID:           key,
+			Type:         n.Type,
+			NodeID:       n.NodeID,
+			IP:           n.IP,
+			Port:         n.Port,
+			Observed:     observed,
+			Downtime:     downtime,
+			Availability: availability(observed, downtime),
+			Downtimes:    []slaDowntime{},
+		}
+		for _, p := range parts {
+			nr.Downtimes = append(nr.Downtimes, slaDowntime{Start: time.Unix(p.Start, 0), End: time.Unix(p.End, 0)})
+		}
+		sr.Nodes = append(sr.Nodes, nr)
+
+		tr, ok := byType[n.Type]
+		if !ok {
+			tr = &slaTypeReport{Type: n.Type}
+			byType[n.Type] = tr
+			sr.Types = append(sr.Types, tr)
+		}
+		tr.Nodes++
+		tr.Observed += observed
+		tr.Downtime += downtime
+	}
+	st.mu.RUnlock()
+	for _, tr := range sr.Types {
+		tr.Availability = availability(tr.Observed, tr.Downtime)
+	}
+	sort.Slice(sr.Types, func(i, j int) bool { return sr.Types[i].Type < sr.Types[j].Type })
+	sort.Slice(sr.Nodes, func(i, j int) bool { return sr.Nodes[i].ID < sr.Nodes[j].ID })
+	return sr
+}
+
+var (
+	slas     *slaStore
+	slasSave time.Time
+)
+
+var slaPeriods = []struct {
+	Name string
+	Dur  time.Duration
+}{
+	{"day", 24 * time.Hour},
+	{"week", 7 * 24 * time.Hour},
+	{"month", 30 * 24 * time.Hour},
+}
+
+func openSla() {
+	if !globeCfg.Sla.Enable {
+		return
+	}
+	slas = &slaStore{Nodes: make(map[string]*slaNode)}
+	if err := loadGob(globeCfg.Sla.Path, slas); err != nil {
+		log.Println("openSla:", err)
+		slas = &slaStore{Nodes: make(map[string]*slaNode)}
+	}
+	slasSave = time.Now()
+	onExit(saveSla)
+}
+
+func slaRetention() time.Duration {
+	days := globeCfg.Sla.Retention
+	if days <= 0 {
+		days = 400
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// updateSla serverSummary的是否健康为准, 未发布的节点不计; 不在serverSummary中的host/dht取各自的健康列
+func updateSla() {
+	if slas == nil {
+		return
+	}
+	_, _, maxGap := healthRules()
+	keepFrom := time.Now().Add(-slaRetention()).Unix()
+
+	seen := make(map[string]bool)
+	//时间 节点ID 服务器类型 IP port 所属hostID *是否发布 *是否健康
+	for _, rec := range lastCycle.records("serverSummary") {
+		t, ok := recordTime(rec)
+		typ := svcTypeName(rec[2])
+		seen[topoID(typ, rec[1])] = true
+		if !ok || rec[6] == "0" {
+			continue
+		}
+		slas.observe(typ, rec[1], rec[3], rec[4], t, rec[7] == "1", maxGap, keepFrom)
+	}
+	for _, c := range []struct{ action, healthy string }{{"host", "healthy"}, {"dht", "heathy"}} {
+		as := schemaOf(c.action)
+		for _, rec := range lastCycle.records(c.action) {
+			t, ok := recordTime(rec)
+			if !ok || seen[topoID(c.action, rec[1])] {
+				continue
+			}
+			ip, port := rec[2], rec[3]
+			if c.action == "dht" {
+				ip, port = rec[3], rec[4]
+			}
+			slas.observe(c.action, rec[1], ip, port, t, as.num(rec, c.healthy) == 1, maxGap, keepFrom)
+		}
+	}
+
+	if globeCfg.Output.Prometheus || globeCfg.Output.PushGateway {
+		now := time.Now()
+		// 不再观测到的节点在窗口内没有数据, 每个周期重新设置
+		sla_availability.Reset()
+		for _, p := range slaPeriods {
+			for _, nr := range slas.report(now.Add(-p.Dur), now).Nodes {
+				sla_availability.WithLabelValues(nr.ID, nr.Type, p.Name).Set(nr.Availability)
+			}
+		}
+	}
+
+	if time.Since(slasSave) < time.Duration(globeCfg.Sla.Flush)*time.Second {
+		return
+	}
+	saveSla()
+	slasSave = time.Now()
+}
+
+func saveSla() {
+	slas.mu.RLock()
+	err := saveGob(globeCfg.Sla.Path, slas)
+	slas.mu.RUnlock()
+	if err != nil {
+		log.Println("saveSla:", err)
+	}
+}
+
+const slaTimeLayout = "2006-01-02 15:04:05"
+
+func (d slaDowntime) String() string {
+	return d.Start.Format(slaTimeLayout) + "~" + d.End.Format(slaTimeLayout)
+}
+
+func writeSlaCSV(w io.Writer, sr *slaReport) {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"type", "nodeId", "ip", "port", "observedSeconds", "downtimeSeconds", "availability", "downtimes"})
+	for _, nr := range sr.Nodes {
+		ds := make([]string, len(nr.Downtimes))
+		for i, d := range nr.Downtimes {
+			ds[i] = d.String()
+		}
+		cw.Write([]string{nr.Type, nr.NodeID, nr.IP, nr.Port, strconv.FormatInt(nr.Observed, 10), strconv.FormatInt(nr.Downtime, 10),
+			strconv.FormatFloat(nr.Availability*100, 'f', 3, 64) + "%", strings.Join(ds, ";")})
+	}
+	for _, tr := range sr.Types {
+		cw.Write([]string{tr.Type, "all", "", "", strconv.FormatInt(tr.Observed, 10), strconv.FormatInt(tr.Downtime, 10),
+			strconv.FormatFloat(tr.Availability*100, 'f', 3, 64) + "%", ""})
+	}
+	cw.Flush()
+}
+
+var slaHTML = template.Must(template.New("sla").Funcs(template.FuncMap{
+	"pct":  func(v float64) string { return strconv.FormatFloat(v*100, 'f', 3, 64) + "%" },
+	"dur":  func(s int64) string { return (time.Duration(s) * time.Second).String() },
+	"time": func(t time.Time) string { return t.Format(slaTimeLayout) },
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>SLA {{time .From}} ~ {{time .To}}</title>
+<style>table{border-collapse:collapse}td,th{border:1px solid #999;padding:2px 8px}</style>
+</head><body>
+<h2>SLA {{time .From}} ~ {{time .To}}</h2>
+<table>
+<tr><th>type</th><th>nodes</th><th>observed</th><th>downtime</th><th>availability</th></tr>
+{{range .Types}}<tr><td>{{.Type}}</td><td>{{.Nodes}}</td><td>{{dur .Observed}}</td><td>{{dur .Downtime}}</td><td>{{pct .Availability}}</td></tr>
+{{end}}</table>
+<h3>nodes</h3>
+<table>
+<tr><th>node</th><th>ip:port</th><th>observed</th><th>downtime</th><th>availability</th><th>downtime intervals</th></tr>
+{{range .Nodes}}<tr><td>{{.ID}}</td><td>{{.IP}}:{{.Port}}</td><td>{{dur .Observed}}</td><td>{{dur .Downtime}}</td><td>{{pct .Availability}}</td><td>{{range .Downtimes}}{{time .Start}} ~ {{time .End}}<br>{{end}}</td></tr>
+{{end}}</table>
+</body></html>
+`))
+
+// /api/v1/sla?from=&to=&format=csv|html, 默认最近30天
+func slaHandler(w http.ResponseWriter, r *http.Request) {
+	if slas == nil {
+		http.Error(w, "sla disabled", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	to, err := parseAPIEnd(q.Get("to"), time.Now())
+	if err != nil {
+		http.Error(w, "invalid to", http.StatusBadRequest)
+		return
+	}
+	from, err := parseAPITime(q.Get("from"), to.AddDate(0, 0, -30))
+	if err != nil {
+		http.Error(w, "invalid from", http.StatusBadRequest)
+		return
+	}
+	sr := slas.report(from, to)
+	switch q.Get("format") {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		writeSlaCSV(w, sr)
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := slaHTML.Execute(w, sr); err != nil {
+			log.Println("slaHandler:", err)
+		}
+	default:
+		writeJSON(w, http.StatusOK, sr)
+	}
+}
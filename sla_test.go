@@ -0,0 +1,83 @@
+// sla_test
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stvp/assert"
+)
+
+func TestSlaReport(t *testing.T) {
+	st := &slaStore{Nodes: make(map[string]*slaNode)}
+	base := time.Date(2017, 7, 4, 0, 0, 0, 0, time.Local)
+	gap := 10 * time.Minute
+	// 每3分钟一条, 第10~19条不健康, 第30~39条无数据
+	for i := 0; i < 60; i++ {
+		if i >= 30 && i < 40 {
+			continue
+		}
+		ts := base.Add(time.Duration(i) * 3 * time.Minute)
+		st.observe("host", "10001", "175.102.132.81", "11015", ts, i < 10 || i >= 20, gap, 0)
+		st.observe("host", "10001", "175.102.132.81", "11015", ts, i < 10 || i >= 20, gap, 0)
+		st.observe("host", "10000", "103.25.23.75", "11015", ts, true, gap, 0)
+	}
+
+	sr := st.report(base, base.Add(24*time.Hour))
+	assert.Equal(t, 2, len(sr.Nodes), "")
+	nr := sr.Nodes[1]
+	assert.Equal(t, "host:10001", nr.ID, "")
+	assert.Equal(t, int64((29+19)*180), nr.Observed, "")
+	assert.Equal(t, int64(10*180), nr.Downtime, "")
+	assert.Equal(t, 1, len(nr.Downtimes), "")
+	assert.Equal(t, base.Add(30*time.Minute), nr.Downtimes[0].Start, "")
+	assert.Equal(t, base.Add(60*time.Minute), nr.Downtimes[0].End, "")
+	assert.Equal(t, 1.0, sr.Nodes[0].Availability, "")
+	assert.Equal(t, 1, len(sr.Types), "")
+	assert.Equal(t, 2, sr.Types[0].Nodes, "")
+
+	part := st.report(base.Add(45*time.Minute), base.Add(24*time.Hour))
+	assert.Equal(t, int64(15*60), part.Nodes[1].Downtime, "")
+
+	var b bytes.Buffer
+	writeSlaCSV(&b, sr)
+	assert.True(t, strings.Contains(b.String(), "2017-07-04 00:30:00~2017-07-04 01:00:00"), "")
+	b.Reset()
+	assert.Nil(t, slaHTML.Execute(&b, sr), "")
+}
+
+func TestSlaHandlerDates(t *testing.T) {
+	saved := slas
+	defer func() { slas = saved }()
+	slas = &slaStore{Nodes: make(map[string]*slaNode)}
+	last := time.Date(2017, 7, 31, 12, 0, 0, 0, time.Local)
+	slas.observe("host", "10000", "103.25.23.75", "11015", last, true, 10*time.Minute, 0)
+	slas.observe("host", "10000", "103.25.23.75", "11015", last.Add(3*time.Minute), false, 10*time.Minute, 0)
+
+	// --to只给日期时包含这一天
+	w := httptest.NewRecorder()
+	slaHandler(w, httptest.NewRequest("GET", "/api/v1/sla?from=2017-07-01&to=2017-07-31", nil))
+	var sr slaReport
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &sr), "")
+	assert.True(t, sr.To.Equal(time.Date(2017, 8, 1, 0, 0, 0, 0, time.Local)), "")
+	assert.Equal(t, 1, len(sr.Nodes), "")
+	assert.Equal(t, int64(180), sr.Nodes[0].Observed, "")
+}
+
+func TestSlaGauges(t *testing.T) {
+	saved, savedCfg, savedOutput := slas, globeCfg.Sla, globeCfg.Output
+	defer func() { slas, globeCfg.Sla, globeCfg.Output = saved, savedCfg, savedOutput }()
+	slas = &slaStore{Nodes: make(map[string]*slaNode)}
+	globeCfg.Sla.Path = filepath.Join(t.TempDir(), "sla.gob")
+	globeCfg.Output.Prometheus = true
+
+	// 不再观测到的节点不再导出
+	sla_availability.WithLabelValues("host:10000", "host", "1h").Set(1)
+	updateSla()
+	assert.Equal(t, 0, collectCount(sla_availability), "")
+}
@@ -0,0 +1,71 @@
+// snapshot
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// snapshotValue 记录中的一列, name为英文名, desc为VDN返回的中文描述
+type snapshotValue struct {
+	Name  string      `json:"name"`
+	Desc  string      `json:"desc"`
+	Value interface{} `json:"value"`
+}
+
+type actionSnapshot struct {
+	Action    string            `json:"action"`
+	Vdn       string            `json:"vdn"`
+	Collected time.Time         `json:"collected"`
+	Error     string            `json:"error,omitempty"`
+	Fields    []schemaField     `json:"fields"`
+	Records   [][]snapshotValue `json:"records"`
+}
+
+// buildSnapshot 将最近一个周期的记录转为带类型的值, 每列同时给出英文名和desc
+func buildSnapshot(as *actionSchema) *actionSnapshot {
+	snap := &actionSnapshot{
+		Action:  as.Action,
+		Vdn:     as.Vdn,
+		Fields:  as.Fields,
+		Records: [][]snapshotValue{},
+	}
+	ac := lastCycle.get(as.Action)
+	if ac == nil {
+		return snap
+	}
+	snap.Collected = ac.Collected
+	snap.Error = ac.LastErr
+	for _, rec := range ac.Records {
+		vs := make([]snapshotValue, 0, len(as.Fields))
+		for i := range as.Fields {
+			if i >= len(rec) {
+				break
+			}
+			f := &as.Fields[i]
+			vs = append(vs, snapshotValue{Name: f.Name, Desc: strings.TrimSpace(f.Desc), Value: typedValue(f, rec[i])})
+		}
+		snap.Records = append(snap.Records, vs)
+	}
+	return snap
+}
+
+// /api/v1/snapshot 及 /api/v1/snapshot/{action}
+func snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/snapshot"), "/")
+	if action == "" {
+		snaps := make(map[string]*actionSnapshot, len(actionSchemas))
+		for _, as := range actionSchemas {
+			snaps[as.Action] = buildSnapshot(as)
+		}
+		writeJSON(w, http.StatusOK, snaps)
+		return
+	}
+	as := schemaOf(action)
+	if as == nil {
+		http.Error(w, "unknown action: "+action, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, buildSnapshot(as))
+}
@@ -0,0 +1,37 @@
+// snapshot_test
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stvp/assert"
+)
+
+func TestBuildSnapshot(t *testing.T) {
+	commitRecords("dht", `2017.07.04 14:45:40.973|20001|0|103.25.23.75|10021|1|1|2|4364|325|6421|14|[{"host_id":10000,"host_pid":14105,"host_ip":"103.25.23.75","host_port":11015}]|118|5022|115,3,0,0,0,0`)
+
+	as := schemaOf("statistic.DHT.action")
+	assert.Equal(t, "dht", as.Action, "")
+	assert.Equal(t, 16, len(as.Fields), "")
+
+	snap := buildSnapshot(as)
+	assert.Equal(t, 1, len(snap.Records), "")
+	rec := make(map[string]interface{})
+	for _, v := range snap.Records[0] {
+		rec[v.Name] = v.Value
+		rec[v.Desc] = v.Value
+	}
+	assert.Equal(t, "20001", rec["dhtId"], "")
+	assert.Equal(t, int64(4364), rec["online"], "")
+	assert.Equal(t, []int64{115, 3, 0, 0, 0, 0}, rec["getvalueSpeed"], "")
+	tm, _ := rec["time"].(time.Time)
+	assert.Equal(t, 973000000, tm.Nanosecond(), "")
+	hosts, _ := rec["hosts"].([]interface{})
+	assert.Equal(t, 1, len(hosts), "")
+
+	// 每列同时有英文名和desc
+	assert.Equal(t, int64(2), rec["路由表个数"], "")
+	assert.Equal(t, "103.25.23.75", rec["DHT的KAD IP"], "")
+	assert.Equal(t, snapshotValue{Name: "ip", Desc: "DHT的KAD IP", Value: "103.25.23.75"}, snap.Records[0][3], "")
+}
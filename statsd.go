@@ -0,0 +1,112 @@
+// statsd
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var ( //statsd
+	statsd_dropped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "p2p",
+			Subsystem: "ops",
+			Name:      "statsd_dropped_messages_total",
+			Help:      "messages dropped because the statsd buffer is full.",
+		},
+	)
+	statsd_buffered = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "ops",
+			Name:      "statsd_buffered_messages",
+			Help:      "messages waiting to be sent to statsd.",
+		},
+	)
+)
+
+func regStatsd() {
+	prometheus.MustRegister(statsd_dropped)
+	prometheus.MustRegister(statsd_buffered)
+}
+
+var statsdTagEscaper = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
+
+// statsdTags DogStatsD标签, 节点ID/HostID与Telegraf的tag名一致, IP Port等用小写
+func statsdTags(s *sample) string {
+	var tags []string
+	for _, l := range s.Labels {
+		name, ok := telegrafTags[s.Action][l.Name]
+		if !ok {
+			name = strings.ToLower(l.Name)
+		}
+		tags = append(tags, name+":"+statsdTagEscaper.Replace(l.Value))
+	}
+	return strings.Join(tags, ",")
+}
+
+// statsdSink 经UDP写StatsD/DogStatsD agent, 最近3分钟的统计量为计数器, 其余为gauge
+// 没有时间戳, agent按收到的时间聚合
+type statsdSink struct {
+	*connQueue
+	prefix string
+	tags   bool // DogStatsD标签; 否则节点ID放在指标名里
+}
+
+func newStatsdSink() (*statsdSink, error) {
+	cfg := &globeCfg.Statsd
+	if cfg.Addr == "" {
+		return nil, errors.New("statsd: addr required")
+	}
+	sd := &statsdSink{
+		connQueue: newConnQueue("udp", cfg.Addr, cfg.Buffer, 0, cfg.Payload),
+		prefix:    cfg.Prefix,
+		tags:      cfg.Dogstatsd,
+	}
+	sd.dropped, sd.buffered = statsd_dropped, statsd_buffered
+	if sd.prefix == "" {
+		sd.prefix = "p2p"
+	}
+	return sd, nil
+}
+
+func (sd *statsdSink) Name() string { return "statsd" }
+
+// lines 只输出scalar的sample; 负的gauge先置0, 否则会被当作增量
+// 不用tag时节点放在名字里, 与graphite的{id}一样, serverSummary为类型名加NodeID
+func (sd *statsdSink) lines(ss []sample) []string {
+	var ls []string
+	for i := range ss {
+		s := &ss[i]
+		if !s.scalar() {
+			continue
+		}
+		var name, suffix string
+		if sd.tags {
+			name = sd.prefix + "." + s.Action + "." + graphiteField(s)
+			if tags := statsdTags(s); tags != "" {
+				suffix = "|#" + tags
+			}
+		} else {
+			name = graphitePath(sd.prefix+".{action}.{id}.{field}", "", s)
+		}
+		value := strconv.FormatFloat(s.Value, 'f', -1, 64)
+		switch {
+		case s.Window:
+			ls = append(ls, name+":"+value+"|c"+suffix+"\n")
+		case s.Value < 0:
+			ls = append(ls, name+":0|g"+suffix+"\n", name+":"+value+"|g"+suffix+"\n")
+		default:
+			ls = append(ls, name+":"+value+"|g"+suffix+"\n")
+		}
+	}
+	return ls
+}
+
+func (sd *statsdSink) Write(ss []sample) error {
+	sd.enqueue(sd.lines(ss)...)
+	return sd.flush()
+}
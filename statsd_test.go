@@ -0,0 +1,71 @@
+// statsd_test
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stvp/assert"
+)
+
+func TestStatsdLines(t *testing.T) {
+	sd := &statsdSink{prefix: "p2p", tags: true}
+	ls := sd.lines(cmSamples("1"))
+	assert.Equal(t, 8, len(ls), "")
+	assert.Equal(t, "p2p.cm.onphone:10|g|#id:1,hostId:10000,ip:103.25.23.75,port:8000\n", ls[0], "")
+	// 最近3分钟的统计量为计数器
+	assert.Equal(t, "p2p.cm.new_onphone_video:3|c|#id:1,hostId:10000,ip:103.25.23.75,port:8000\n", ls[1], "")
+
+	sd.tags = false
+	ls = sd.lines(cmSamples("1"))
+	assert.Equal(t, "p2p.cm.1.onphone:10|g\n", ls[0], "")
+
+	ss := cmSamples("1")
+	ss[0].Value = -2
+	ls = sd.lines(ss[:1])
+	assert.Equal(t, []string{"p2p.cm.1.onphone:0|g\n", "p2p.cm.1.onphone:-2|g\n"}, ls, "")
+
+	// 不用tag时serverSummary不同类型的同号节点不重名
+	ss = nil
+	for _, rec := range []string{
+		"2017.07.04 14:45:41.639|5|8|10.0.0.5|80||1|1",
+		"2017.07.04 14:45:41.639|5|3|10.0.0.6|80|1|1|0",
+	} {
+		ss = append(ss, recordSamples(schemaOf("serverSummary"), strings.Split(rec, "|"), time.Now())...)
+	}
+	ls = sd.lines(ss)
+	assert.Equal(t, []string{
+		"p2p.serverSummary.relay.5.published:1|g\n", "p2p.serverSummary.relay.5.healthy:1|g\n",
+		"p2p.serverSummary.cm.5.published:1|g\n", "p2p.serverSummary.cm.5.healthy:0|g\n",
+	}, ls, "")
+}
+
+func TestStatsdUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err, "")
+	defer pc.Close()
+
+	saved := globeCfg.Statsd
+	defer func() { globeCfg.Statsd = saved }()
+	globeCfg.Statsd.Addr = pc.LocalAddr().String()
+	globeCfg.Statsd.Prefix = ""
+	globeCfg.Statsd.Dogstatsd = true
+	globeCfg.Statsd.Payload = 200
+	sd, err := newStatsdSink()
+	assert.Nil(t, err, "")
+
+	assert.Nil(t, sd.Write(cmSamples("1", "2")), "")
+	var lines []string
+	buf := make([]byte, 1500)
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	for len(lines) < 16 {
+		n, _, err := pc.ReadFrom(buf)
+		assert.Nil(t, err, "")
+		assert.True(t, n <= 200, "")
+		lines = append(lines, strings.Split(strings.TrimSuffix(string(buf[:n]), "\n"), "\n")...)
+	}
+	assert.Equal(t, 16, len(lines), "")
+	assert.True(t, strings.HasPrefix(lines[8], "p2p.cm.onphone:10|g|#id:2,"), "")
+}
@@ -0,0 +1,205 @@
+// telegraf
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var ( //telegraf
+	telegraf_dropped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "p2p",
+			Subsystem: "ops",
+			Name:      "telegraf_dropped_lines_total",
+			Help:      "lines dropped because the telegraf buffer is full.",
+		},
+	)
+	telegraf_buffered = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Subsystem: "ops",
+			Name:      "telegraf_buffered_lines",
+			Help:      "lines waiting to be sent to telegraf.",
+		},
+	)
+)
+
+func regTelegraf() {
+	prometheus.MustRegister(telegraf_dropped)
+	prometheus.MustRegister(telegraf_buffered)
+}
+
+// 与原有Telegraf输出保持一致的tag名, IP/Port合并为addr, 没有标签的action为host=all
+var telegrafTags = map[string]map[string]string{
+	"serverSummary": {"NodeID": "nodeId", "SvcType": "svcType", "HostID": "hostId"},
+	"host":          {"HostID": "id"},
+	"relay":         {"RelayId": "id"},
+	"bootstrap":     {"BootstrapId": "id"},
+	"dht":           {"DhtId": "id", "HostId": "hostId"},
+	"sps":           {"SpsId": "id", "HostId": "hostId"},
+	"anps":          {"ApnsId": "id", "HostId": "hostId"},
+	"cm":            {"CmId": "id", "HostId": "hostId"},
+}
+
+// 与原有Telegraf输出保持一致的field名, 未列出的用schema中的名字
+var telegrafFields = map[string]map[string]string{
+	"userStatistic": {"login": "loginX", "logout": "logoutX"},
+	"callStatistic": {
+		"onphoneV": "onvideo", "onphoneA": "onaudio", "callTraffic": "trafficX",
+		"blockedCall": "blockedX", "releasedCall": "releasedX", "breakedCall": "brokenX",
+	},
+	"host": {
+		"fixedUser": "fixed_user", "onlineUser": "online_user", "onlineSeat": "online_seat",
+		"onlineAnonym": "online_anonym", "untreatedTask": "untreated_task",
+		"login": "loginX", "logout": "logoutX", "loginUser": "login_userX", "logoutUser": "logout_userX",
+		"queryCalled": "query_calledX", "queryCalledSuc": "query_called_okX", "queryCalledDHT": "query_called_DHT_X",
+		"relayMsg": "relay_msgX", "relayMsgCAHCESuc": "relay_msg_CAHCE_okX", "relayMsgQueryDHT": "relay_msg_query_DHT_X",
+		"relayMsgLocalSuc": "relay_msg_local_okX", "relaySeatMsg": "relay_seat_msgX", "relayUserQueuePos": "relay_user_queue_posX",
+		"pushAPNS": "push_APNS_X", "pushSilent": "push_silentX",
+	},
+	"relay": {
+		"shortLiveMsg": "short_live_msgX", "buildingMsg": "building_msgX", "media": "media_packetX",
+		"invalidMsg": "invalid_msgX", "callBeg": "call_setupX", "callEnd": "call_endX",
+		"upStream": "up_streamX", "downStream": "down_streamX",
+	},
+	"bootstrap": {"query": "queryX", "heathyHost": "heathy_host", "route": "route_len"},
+	"dht":       {"route": "route_table"},
+	"sps": {
+		"msg": "send_msgX", "hostMsg": "send_host_msgX", "clientMsg": "send_client_msgX",
+		"silentMsg": "send_silent_msgX", "silentMsgOk": "send_silent_msg_okX",
+	},
+	"anps": {"pushed": "pushedX", "pushSucced": "push_okX", "pushFailed": "push_nokX"},
+	"cm": {
+		"onphoneV": "onvideoX", "onphoneA": "onaudioX", "hangup": "releasedX", "broken": "brokenX",
+		"blockBySys": "sys_blockX", "blockByOps": "ops_blockX", "blockOffline": "offline_blockX",
+	},
+}
+
+func telegrafField(s *sample) string {
+	if name, ok := telegrafFields[s.Action][s.Field]; ok {
+		return name
+	}
+	return s.Field
+}
+
+func telegrafTagSet(s *sample) []label {
+	as := schemaOf(s.Action)
+	var ts []label
+	for _, f := range as.Fields {
+		switch f.Label {
+		case "", "Port":
+		case "IP":
+			ts = append(ts, label{Name: "addr", Value: s.label("IP") + ":" + s.label("Port")})
+		default:
+			name, ok := telegrafTags[s.Action][f.Label]
+			if !ok {
+				name = f.Label
+			}
+			ts = append(ts, label{Name: name, Value: s.label(f.Label)})
+		}
+	}
+	if len(ts) == 0 {
+		return []label{{Name: "host", Value: "all"}}
+	}
+	return ts
+}
+
+// telegrafLines 同一条记录的sample合并为一行, 时间戳取记录时间; 列表项(分类终端等)不输出
+func telegrafLines(ss []sample) []byte {
+	var b bytes.Buffer
+	var p *linePoint
+	prev := ""
+	for i := range ss {
+		s := &ss[i]
+		if s.label("category") != "" {
+			continue
+		}
+		key := seriesKey(s.Action, s.Labels) + strconv.FormatInt(s.Time.UnixNano(), 10)
+		if key != prev {
+			if p != nil {
+				p.encode(&b)
+			}
+			p = &linePoint{Measurement: "p2p_" + s.Action, Tags: telegrafTagSet(s), Time: s.Time}
+			prev = key
+		}
+		p.addField(telegrafField(s), int64(s.Value))
+	}
+	if p != nil {
+		p.encode(&b)
+	}
+	return b.Bytes()
+}
+
+type telegrafSink struct {
+	*connQueue
+}
+
+// telegrafTLS 按配置加载CA和客户端证书
+func telegrafTLS() (*tls.Config, error) {
+	o := &globeCfg.Output
+	tc := &tls.Config{InsecureSkipVerify: o.TelegrafInsecure}
+	if o.TelegrafCA != "" {
+		pem, err := ioutil.ReadFile(o.TelegrafCA)
+		if err != nil {
+			return nil, err
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificate in " + o.TelegrafCA)
+		}
+	}
+	if o.TelegrafCert != "" {
+		cert, err := tls.LoadX509KeyPair(o.TelegrafCert, o.TelegrafKey)
+		if err != nil {
+			return nil, err
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
+
+// newTelegrafSink addr与Telegraf socket_listener一致:
+// tcp://:8094 udp://:8094 unix:///var/run/telegraf.sock unixgram:///var/run/telegraf.sock tls://host:8094
+// 连接在Write时建立, 断开后退避重连
+func newTelegrafSink(addr string) (*telegrafSink, error) {
+	ta := strings.Split(addr, "://")
+	if len(ta) != 2 {
+		return nil, errors.New("invalid TelegrafAddr: " + addr)
+	}
+	o := &globeCfg.Output
+	ts := &telegrafSink{newConnQueue(ta[0], ta[1], o.TelegrafBuffer, o.TelegrafTimeout, o.TelegrafPayload)}
+	ts.dropped, ts.buffered = telegraf_dropped, telegraf_buffered
+	switch ts.network {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6", "unix", "unixgram":
+	case "tls":
+		tc, err := telegrafTLS()
+		if err != nil {
+			return nil, err
+		}
+		ts.tls = tc
+	default:
+		return nil, errors.New("unsupported TelegrafAddr: " + addr)
+	}
+	return ts, nil
+}
+
+func (ts *telegrafSink) Name() string { return "telegraf" }
+
+func (ts *telegrafSink) Write(ss []sample) error {
+	var lines []string
+	for _, l := range bytes.SplitAfter(telegrafLines(ss), []byte("\n")) {
+		if len(l) > 0 {
+			lines = append(lines, string(l))
+		}
+	}
+	ts.enqueue(lines...)
+	return ts.flush()
+}
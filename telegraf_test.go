@@ -0,0 +1,93 @@
+// telegraf_test
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stvp/assert"
+)
+
+func cmSamples(ids ...string) []sample {
+	var ss []sample
+	for _, id := range ids {
+		rec := strings.Split("2017.07.04 14:45:41.639|"+id+"|10000|103.25.23.75|8000|10|3|7|20|1|2|3|4", "|")
+		ss = append(ss, recordSamples(schemaOf("cm"), rec, time.Now())...)
+	}
+	return ss
+}
+
+func TestTelegrafReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err, "")
+	addr := ln.Addr().String()
+	ln.Close()
+
+	ts, err := newTelegrafSink("tcp://" + addr)
+	assert.Nil(t, err, "")
+	ts.buffer = 2
+
+	// Telegraf没起来时缓存, 超出上限丢弃最旧的
+	assert.NotNil(t, ts.Write(cmSamples("1")), "")
+	assert.Nil(t, ts.Write(cmSamples("2", "3")), "")
+	assert.Equal(t, 2, len(ts.pending), "")
+	assert.True(t, strings.Contains(ts.pending[0], ",id=2 "), "")
+
+	ln, err = net.Listen("tcp", addr)
+	assert.Nil(t, err, "")
+	defer ln.Close()
+	lines := make(chan string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewScanner(conn)
+		for r.Scan() {
+			lines <- r.Text()
+		}
+	}()
+
+	ts.retryAt = time.Time{}
+	assert.Nil(t, ts.Write(cmSamples("4")), "")
+	assert.Equal(t, 0, len(ts.pending), "")
+	for _, id := range []string{"3", "4"} {
+		select {
+		case l := <-lines:
+			assert.True(t, strings.Contains(l, ",id="+id+" "), l)
+		case <-time.After(time.Second):
+			t.Fatal("line not received")
+		}
+	}
+}
+
+func TestTelegrafUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err, "")
+	defer pc.Close()
+
+	ts, err := newTelegrafSink("udp://" + pc.LocalAddr().String())
+	assert.Nil(t, err, "")
+	ts.payload = 300
+	assert.Nil(t, ts.Write(cmSamples("1", "2", "3", "4", "5")), "")
+
+	// 每个数据报只含完整的行且不超过payload
+	var lines []string
+	buf := make([]byte, 2048)
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	for len(lines) < 5 {
+		n, _, err := pc.ReadFrom(buf)
+		assert.Nil(t, err, "")
+		assert.True(t, n <= 300, "")
+		assert.True(t, strings.HasSuffix(string(buf[:n]), "\n"), "")
+		lines = append(lines, strings.Split(strings.TrimSuffix(string(buf[:n]), "\n"), "\n")...)
+	}
+	assert.True(t, strings.Contains(lines[4], ",id=5 "), "")
+
+	_, err = newTelegrafSink("http://127.0.0.1:8094")
+	assert.NotNil(t, err, "")
+}
@@ -0,0 +1,220 @@
+// topology
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	nodeHealthy     = "healthy"
+	nodeUnhealthy   = "unhealthy"
+	nodeUnpublished = "unpublished"
+	nodeUnknown     = "unknown"
+)
+
+type topoNode struct {
+	ID     string `json:"id"` // 类型:节点ID, relay与bootstrap的节点ID会重复
+	Type   string `json:"type"`
+	NodeID string `json:"nodeId"`
+	IP     string `json:"ip"`
+	Port   string `json:"port"`
+	HostID string `json:"hostId,omitempty"`
+	Status string `json:"status"`
+}
+
+type topoEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Kind string `json:"kind"`
+}
+
+type topology struct {
+	Nodes []*topoNode `json:"nodes"`
+	Edges []topoEdge  `json:"edges"`
+	Time  time.Time   `json:"time"`
+
+	byID map[string]*topoNode
+}
+
+func topoID(typ, nodeID string) string {
+	return typ + ":" + nodeID
+}
+
+// host子服务, 对应action的记录中第2列为所属Host节点ID, 第3列为IP
+var hostChildTypes = map[string]bool{
+	"dht":  true,
+	"sps":  true,
+	"anps": true,
+	"cm":   true,
+}
+
+func isHostID(id string) bool {
+	return id != "" && id != "0"
+}
+
+func (tp *topology) node(typ, nodeID, ip, port string) *topoNode {
+	id := topoID(typ, nodeID)
+	if n, ok := tp.byID[id]; ok {
+		return n
+	}
+	n := &topoNode{ID: id, Type: typ, NodeID: nodeID, IP: ip, Port: port, Status: nodeUnknown}
+	tp.byID[id] = n
+	tp.Nodes = append(tp.Nodes, n)
+	return n
+}
+
+func (tp *topology) edge(from, to, kind string) {
+	tp.Edges = append(tp.Edges, topoEdge{From: from, To: to, Kind: kind})
+}
+
+// buildTopology 由serverSummary/各服务记录/DHT的Host列表构建集群拓扑
+func buildTopology() *topology {
+	tp := &topology{byID: make(map[string]*topoNode), Time: time.Now()}
+
+	//时间 节点ID 服务器类型 IP port 所属hostID *是否发布 *是否健康
+	for _, rec := range lastCycle.records("serverSummary") {
+		n := tp.node(svcTypeName(rec[2]), rec[1], rec[3], rec[4])
+		if isHostID(rec[5]) && n.Type != "host" {
+			n.HostID = rec[5]
+		}
+		published, _ := strconv.Atoi(rec[6])
+		healthy, _ := strconv.Atoi(rec[7])
+		switch {
+		case published == 0:
+			n.Status = nodeUnpublished
+		case healthy == 1:
+			n.Status = nodeHealthy
+		default:
+			n.Status = nodeUnhealthy
+		}
+	}
+	for _, rec := range lastCycle.records("bootstrap") {
+		tp.node("bootstrap", rec[1], rec[2], rec[3])
+	}
+	for _, rec := range lastCycle.records("host") {
+		n := tp.node("host", rec[1], rec[2], rec[3])
+		if n.Status == nodeUnknown {
+			n.Status = statusOf(rec[4])
+		}
+	}
+	for _, rec := range lastCycle.records("relay") {
+		tp.node("relay", rec[1], rec[2], rec[3])
+	}
+	for typ := range hostChildTypes {
+		for _, rec := range lastCycle.records(typ) {
+			n := tp.node(typ, rec[1], rec[3], rec[4])
+			if isHostID(rec[2]) {
+				n.HostID = rec[2]
+			}
+		}
+	}
+
+	hostsByIP := make(map[string]string)
+	var hosts, bootstraps []*topoNode
+	for _, n := range tp.Nodes {
+		switch n.Type {
+		case "host":
+			hostsByIP[n.IP] = n.NodeID
+			hosts = append(hosts, n)
+		case "bootstrap":
+			bootstraps = append(bootstraps, n)
+		}
+	}
+	for _, n := range tp.Nodes {
+		if hostChildTypes[n.Type] && !isHostID(n.HostID) {
+			n.HostID = hostsByIP[n.IP]
+		}
+	}
+	sort.Slice(tp.Nodes, func(i, j int) bool { return tp.Nodes[i].ID < tp.Nodes[j].ID })
+
+	for _, b := range bootstraps {
+		for _, h := range hosts {
+			tp.edge(b.ID, h.ID, "bootstrap")
+		}
+	}
+	for _, n := range tp.Nodes {
+		if hostChildTypes[n.Type] && isHostID(n.HostID) {
+			tp.edge(topoID("host", n.HostID), n.ID, "child")
+		}
+	}
+	for _, n := range tp.Nodes {
+		if n.Type != "relay" {
+			continue
+		}
+		for _, b := range bootstraps {
+			tp.edge(b.ID, n.ID, "relay")
+		}
+	}
+	//DHT记录第12列 Host列表
+	for _, rec := range lastCycle.records("dht") {
+		for _, h := range dhtHostList(rec[12]) {
+			tp.edge(topoID("dht", rec[1]), topoID("host", strconv.Itoa(h.HostID)), "dht-route")
+		}
+	}
+	return tp
+}
+
+func statusOf(healthy string) string {
+	if healthy == "1" {
+		return nodeHealthy
+	}
+	return nodeUnhealthy
+}
+
+type dhtHost struct {
+	HostID   int    `json:"host_id"`
+	HostPid  int    `json:"host_pid"`
+	HostIP   string `json:"host_ip"`
+	HostPort int    `json:"host_port"`
+}
+
+func dhtHostList(s string) []dhtHost {
+	var hs []dhtHost
+	if err := json.Unmarshal([]byte(s), &hs); err != nil {
+		return nil
+	}
+	return hs
+}
+
+var dotColors = map[string]string{
+	nodeHealthy:     "palegreen",
+	nodeUnhealthy:   "tomato",
+	nodeUnpublished: "lightgrey",
+	nodeUnknown:     "white",
+}
+
+// dot 输出Graphviz格式, 节点按健康状态着色
+func (tp *topology) dot() []byte {
+	var b bytes.Buffer
+	b.WriteString("digraph vdn {\n\trankdir=LR;\n\tnode [shape=box, style=filled];\n")
+	for _, n := range tp.Nodes {
+		fmt.Fprintf(&b, "\t%q [label=\"%s %s\\n%s:%s\", fillcolor=%s];\n",
+			n.ID, n.Type, n.NodeID, n.IP, n.Port, dotColors[n.Status])
+	}
+	for _, e := range tp.Edges {
+		style := "solid"
+		if e.Kind == "dht-route" {
+			style = "dashed"
+		}
+		fmt.Fprintf(&b, "\t%q -> %q [style=%s];\n", e.From, e.To, style)
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+// /api/v1/topology, ?format=dot 输出Graphviz
+func topologyHandler(w http.ResponseWriter, r *http.Request) {
+	tp := buildTopology()
+	if r.URL.Query().Get("format") == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		w.Write(tp.dot())
+		return
+	}
+	writeJSON(w, http.StatusOK, tp)
+}
@@ -0,0 +1,35 @@
+// topology_test
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stvp/assert"
+)
+
+func TestBuildTopology(t *testing.T) {
+	commitRecords("serverSummary",
+		"2017.07.04 14:45:41.639|1|5|103.25.23.74|10000|0|1|1",
+		"2017.07.04 14:45:41.639|10000|4|103.25.23.75|11015|10000|1|1",
+		"2017.07.04 14:45:41.639|10001|4|175.102.132.81|11015|10001|1|0",
+		"2017.07.04 14:45:41.639|50000|6|103.25.23.75|10032|0|1|1",
+		"2017.07.04 14:45:41.639|50001|6|175.102.132.81|10032|0|1|1",
+		"2017.07.04 14:45:41.639|21|8|223.111.205.86|9000|0|0|0",
+	)
+	commitRecords("sps",
+		"2017.07.04 14:45:41.099|50000|0|103.25.23.75|10032|1387|4053|2184|1869|0|0",
+		"2017.07.04 14:45:41.099|50001|0|175.102.132.81|10032|1370|5494|2393|3101|0|0",
+	)
+	tp := buildTopology()
+
+	assert.Equal(t, "10000", tp.byID["sps:50000"].HostID, "")
+	assert.Equal(t, "10001", tp.byID["sps:50001"].HostID, "")
+	assert.Equal(t, nodeUnhealthy, tp.byID["host:10001"].Status, "")
+	assert.Equal(t, nodeUnpublished, tp.byID["relay:21"].Status, "")
+
+	dot := string(tp.dot())
+	t.Log(dot)
+	assert.True(t, strings.Contains(dot, `"host:10001" -> "sps:50001"`), "")
+	assert.True(t, strings.Contains(dot, `"bootstrap:1" -> "relay:21"`), "")
+}
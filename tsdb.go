@@ -0,0 +1,267 @@
+// tsdb
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type tsPoint struct {
+	T int64 // unix秒, 按resolution对齐
+	V float64
+}
+
+// 每个序列一个环形缓冲区, 下标为 (T/resolution)%len(Points)
+type tsSeries struct {
+	Metric string
+	Labels []label
+	Points []tsPoint
+	Last   int64
+}
+
+type tsdb struct {
+	mu         sync.RWMutex
+	Resolution int64
+	Slots      int
+	Series     map[string]*tsSeries
+}
+
+func newTsdb(resolution time.Duration, retention time.Duration) *tsdb {
+	res := int64(resolution / time.Second)
+	if res <= 0 {
+		res = 1
+	}
+	slots := int(int64(retention/time.Second) / res)
+	if slots <= 0 {
+		slots = 1
+	}
+	return &tsdb{Resolution: res, Slots: slots, Series: make(map[string]*tsSeries)}
+}
+
+func (db *tsdb) append(s sample) {
+	t := s.Time.Unix()
+	t -= t % db.Resolution
+	key := seriesKey(s.metric(), s.Labels)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	ts, ok := db.Series[key]
+	if !ok {
+		ts = &tsSeries{Metric: s.metric(), Labels: s.Labels, Points: make([]tsPoint, db.Slots)}
+		db.Series[key] = ts
+	}
+	ts.Points[(t/db.Resolution)%int64(db.Slots)] = tsPoint{T: t, V: s.Value}
+	if t > ts.Last {
+		ts.Last = t
+	}
+}
+
+func matchLabels(ls []label, match []label) bool {
+	for _, m := range match {
+		found := false
+		for _, l := range ls {
+			if l.Name == m.Name && l.Value == m.Value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+type seriesResult struct {
+	Metric string            `json:"metric"`
+	Labels map[string]string `json:"labels"`
+	Points [][2]float64      `json:"points"` // [unix秒, 值]
+}
+
+func labelMap(ls []label) map[string]string {
+	m := make(map[string]string, len(ls))
+	for _, l := range ls {
+		m[l.Name] = l.Value
+	}
+	return m
+}
+
+// query 返回metric下标签匹配的序列在[from,to]内的点
+func (db *tsdb) query(metric string, match []label, from, to int64) []seriesResult {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	rs := []seriesResult{}
+	for _, ts := range db.Series {
+		if ts.Metric != metric || !matchLabels(ts.Labels, match) {
+			continue
+		}
+		r := seriesResult{Metric: ts.Metric, Labels: labelMap(ts.Labels), Points: [][2]float64{}}
+		var ps []tsPoint
+		for _, p := range ts.Points {
+			if p.T != 0 && p.T >= from && p.T <= to {
+				ps = append(ps, p)
+			}
+		}
+		sort.Slice(ps, func(i, j int) bool { return ps[i].T < ps[j].T })
+		for _, p := range ps {
+			r.Points = append(r.Points, [2]float64{float64(p.T), p.V})
+		}
+		rs = append(rs, r)
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		return seriesKey(metric, sortedLabels(rs[i].Labels)) < seriesKey(metric, sortedLabels(rs[j].Labels))
+	})
+	return rs
+}
+
+func sortedLabels(m map[string]string) []label {
+	ls := make([]label, 0, len(m))
+	for k, v := range m {
+		ls = append(ls, label{Name: k, Value: v})
+	}
+	return sortLabels(ls)
+}
+
+// expire 删除超过保留时间未更新的序列
+func (db *tsdb) expire(now int64) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for key, ts := range db.Series {
+		if now-ts.Last > db.Resolution*int64(db.Slots) {
+			delete(db.Series, key)
+		}
+	}
+}
+
+var (
+	store     *tsdb
+	storeSave time.Time
+)
+
+func openTsdb() {
+	if !globeCfg.Tsdb.Enable {
+		return
+	}
+	res := time.Duration(globeCfg.Tsdb.Resolution) * time.Second
+	if res <= 0 {
+		res = time.Duration(globeCfg.Rest.Period) * time.Second
+	}
+	retention := time.Duration(globeCfg.Tsdb.Retention) * time.Hour
+	if retention <= 0 {
+		retention = 7 * 24 * time.Hour
+	}
+	store = newTsdb(res, retention)
+
+	saved := &tsdb{}
+	if err := loadGob(globeCfg.Tsdb.Path, saved); err != nil {
+		log.Println("openTsdb:", err)
+	} else if saved.Resolution == store.Resolution && saved.Slots == store.Slots && saved.Series != nil {
+		store = saved
+	} else if saved.Series != nil {
+		log.Println("openTsdb: resolution/retention changed, discard", globeCfg.Tsdb.Path)
+	}
+	storeSave = time.Now()
+	onExit(saveTsdb)
+}
+
+// updateTsdb 把本周期的记录写入store, 并按flush间隔落盘
+func updateTsdb(ss []sample) {
+	if store == nil {
+		return
+	}
+	for _, s := range ss {
+		store.append(s)
+	}
+
+	flush := time.Duration(globeCfg.Tsdb.Flush) * time.Second
+	if time.Since(storeSave) < flush {
+		return
+	}
+	store.expire(time.Now().Unix())
+	saveTsdb()
+	storeSave = time.Now()
+}
+
+func saveTsdb() {
+	store.mu.RLock()
+	err := saveGob(globeCfg.Tsdb.Path, store)
+	store.mu.RUnlock()
+	if err != nil {
+		log.Println("saveTsdb:", err)
+	}
+}
+
+// parseAPITime 支持unix秒, RFC3339及日期(本地时间0点)
+func parseAPITime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// parseAPIEnd 区间的结束时间, 只给日期时为该日结束(次日0点), 包含这一整天
+func parseAPIEnd(s string, def time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t.AddDate(0, 0, 1), nil
+	}
+	return parseAPITime(s, def)
+}
+
+// parseLabelMatch HostID=10000,IP=1.2.3.4
+func parseLabelMatch(s string) ([]label, error) {
+	var ls []label
+	if s == "" {
+		return ls, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		p := strings.SplitN(kv, "=", 2)
+		if len(p) != 2 {
+			return nil, errors.New("invalid labels: " + kv)
+		}
+		ls = append(ls, label{Name: strings.TrimSpace(p[0]), Value: strings.Trim(strings.TrimSpace(p[1]), `"`)})
+	}
+	return ls, nil
+}
+
+// /api/v1/query_range?metric=&labels=&from=&to=
+func queryRangeHandler(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		http.Error(w, "tsdb disabled", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	metric := q.Get("metric")
+	if metric == "" {
+		http.Error(w, "metric required", http.StatusBadRequest)
+		return
+	}
+	match, err := parseLabelMatch(q.Get("labels"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	to, err := parseAPITime(q.Get("to"), now)
+	if err != nil {
+		http.Error(w, "invalid to", http.StatusBadRequest)
+		return
+	}
+	from, err := parseAPITime(q.Get("from"), to.Add(-time.Hour))
+	if err != nil {
+		http.Error(w, "invalid from", http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, store.query(metric, match, from.Unix(), to.Unix()))
+}
@@ -0,0 +1,83 @@
+// tsdb_test
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stvp/assert"
+)
+
+func TestTsdb(t *testing.T) {
+	db := newTsdb(180*time.Second, time.Hour)
+	assert.Equal(t, 20, db.Slots, "")
+
+	ls := sortLabels([]label{{"RelayId", "21"}, {"IP", "223.111.205.86"}})
+	base := time.Unix(1499150741, 0)
+	for i := 0; i < 30; i++ {
+		db.append(sample{Action: "relay", Field: "invalidMsg", Labels: ls, Value: float64(i), Time: base.Add(time.Duration(i) * 3 * time.Minute)})
+	}
+	rs := db.query("relay_invalidMsg", []label{{"RelayId", "21"}}, 0, base.Add(24*time.Hour).Unix())
+	assert.Equal(t, 1, len(rs), "")
+	assert.Equal(t, 20, len(rs[0].Points), "")
+	assert.Equal(t, 10.0, rs[0].Points[0][1], "")
+	assert.Equal(t, 29.0, rs[0].Points[19][1], "")
+	assert.Equal(t, 0, len(db.query("relay_invalidMsg", []label{{"RelayId", "1"}}, 0, base.Add(24*time.Hour).Unix())), "")
+
+	file := filepath.Join(t.TempDir(), "tsdb.gob")
+	assert.Nil(t, saveGob(file, db), "")
+	loaded := &tsdb{}
+	assert.Nil(t, loadGob(file, loaded), "")
+	assert.Equal(t, rs, loaded.query("relay_invalidMsg", nil, 0, base.Add(24*time.Hour).Unix()), "")
+}
+
+func TestRecordSamples(t *testing.T) {
+	as := schemaOf("userStatistic")
+	ss := recordSamples(as, strings.Split("2017.07.04 14:45:41.639|4364|1206|327|281|0|[463,115,50,2726,0,899,0,111,0,0]", "|"), time.Now())
+	assert.Equal(t, 15, len(ss), "")
+	assert.Equal(t, "userStatistic_online", ss[0].metric(), "")
+	assert.False(t, ss[0].Window, "")
+	assert.True(t, ss[3].Window, "")
+	assert.Equal(t, "Android", ss[8].label("category"), "")
+	assert.Equal(t, 2726.0, ss[8].Value, "")
+	assert.True(t, ss[0].scalar(), "")
+	assert.False(t, ss[8].scalar(), "")
+	ss[0].Value = math.NaN()
+	assert.False(t, ss[0].scalar(), "")
+
+	// 解析不了的数值和缺少的分类为0
+	ss = recordSamples(as, strings.Split("2017.07.04 14:45:41.639|4364|-|327|281|0|[463,115]", "|"), time.Now())
+	assert.Equal(t, 15, len(ss), "")
+	assert.Equal(t, 0.0, ss[1].Value, "")
+	assert.Equal(t, "CLOUD_GW", ss[14].label("category"), "")
+	assert.Equal(t, 0.0, ss[14].Value, "")
+}
+
+func TestTsdbExitSave(t *testing.T) {
+	saved, savedStore, savedSavers, savedSinks := globeCfg.Tsdb, store, exitSavers, sinks
+	defer func() { globeCfg.Tsdb, store, exitSavers, sinks = saved, savedStore, savedSavers, savedSinks }()
+	globeCfg.Tsdb.Enable = true
+	globeCfg.Tsdb.Path = filepath.Join(t.TempDir(), "tsdb.gob")
+	globeCfg.Tsdb.Flush = 900
+	exitSavers = nil
+	openTsdb()
+	updateTsdb(cmSamples("1"))
+	fs := &fakeSink{name: "exit"}
+	sinks = &dispatcher{}
+	sinks.add(fs)
+	sinks.emit(cmSamples("1"))
+
+	// 未到flush间隔, 退出时落盘, Sink队列中的数据也写出
+	flushOnExit()
+	db := &tsdb{}
+	assert.Nil(t, loadGob(globeCfg.Tsdb.Path, db), "")
+	assert.Equal(t, len(store.Series), len(db.Series), "")
+	assert.True(t, len(db.Series) > 0, "")
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	assert.Equal(t, 1, len(fs.batches), "")
+	assert.Equal(t, len(cmSamples("1")), len(fs.batches[0]), "")
+}
@@ -0,0 +1,252 @@
+// zabbix
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var ( //zabbix
+	zabbix_failed = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "p2p",
+			Subsystem: "ops",
+			Name:      "zabbix_failed_items_total",
+			Help:      "items the zabbix server did not accept, usually not created yet.",
+		},
+	)
+)
+
+func regZabbix() {
+	prometheus.MustRegister(zabbix_failed)
+}
+
+// 低级发现(LLD)的节点类型, 发现项的key为 p2p.discovery[类型]
+var zabbixDiscoveryTypes = []string{"host", "relay", "dht", "sps", "anps", "cm"}
+
+var zabbixFailed = regexp.MustCompile(`failed: (\d+)`)
+
+type zabbixItem struct {
+	Host  string `json:"host"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Clock int64  `json:"clock,omitempty"`
+	Ns    int    `json:"ns,omitempty"`
+}
+
+type zabbixRequest struct {
+	Request string       `json:"request"`
+	Data    []zabbixItem `json:"data"`
+}
+
+type zabbixResponse struct {
+	Response string `json:"response"`
+	Info     string `json:"info"`
+}
+
+// zabbixParam key参数含, ] " 空格等时加引号
+func zabbixParam(p string) string {
+	if !strings.ContainsAny(p, `,]" `) && !strings.HasPrefix(p, "[") {
+		return p
+	}
+	return `"` + strings.Replace(p, `"`, `\"`, -1) + `"`
+}
+
+// zabbixKey 如p2p.host.online_user[5], 参数为节点ID(schema中第一个标签);
+// serverSummary的节点ID在不同类型间会重复, 参数为[类型,节点ID]; 没有标签的action不带参数
+func zabbixKey(prefix string, s *sample) string {
+	key := prefix + "." + s.Action + "." + graphiteField(s)
+	var params []string
+	if t := s.label("SvcType"); t != "" {
+		params = append(params, svcTypeName(t))
+	}
+	for _, f := range schemaOf(s.Action).Fields {
+		if f.Label != "" {
+			params = append(params, zabbixParam(s.label(f.Label)))
+			break
+		}
+	}
+	if len(params) == 0 {
+		return key
+	}
+	return key + "[" + strings.Join(params, ",") + "]"
+}
+
+// zabbixDiscovery 由集群拓扑生成各类型节点的LLD数据, 可用宏{#NODEID} {#IP} {#PORT} {#HOSTID} {#STATUS}
+func zabbixDiscovery(tp *topology) map[string]string {
+	rows := make(map[string][]map[string]string)
+	for _, n := range tp.Nodes {
+		rows[n.Type] = append(rows[n.Type], map[string]string{
+			"{#NODEID}": n.NodeID,
+			"{#IP}":     n.IP,
+			"{#PORT}":   n.Port,
+			"{#HOSTID}": n.HostID,
+			"{#STATUS}": n.Status,
+		})
+	}
+	lld := make(map[string]string)
+	for _, typ := range zabbixDiscoveryTypes {
+		data := rows[typ]
+		if data == nil {
+			data = []map[string]string{}
+		}
+		b, _ := json.Marshal(map[string]interface{}{"data": data})
+		lld[typ] = string(b)
+	}
+	return lld
+}
+
+// zabbixSink 按sender(trapper)协议写Zabbix server/proxy, 所有节点的监控项都在一个Zabbix主机下
+// 每次请求一个连接; 节点清单变化或超过discovery间隔时附带LLD数据
+type zabbixSink struct {
+	addr      string
+	host      string
+	prefix    string
+	timeout   time.Duration
+	interval  time.Duration // LLD最长重发间隔
+	lastLLD   map[string]string
+	lastLLDAt time.Time
+}
+
+func newZabbixSink() (*zabbixSink, error) {
+	cfg := &globeCfg.Zabbix
+	if cfg.Addr == "" || cfg.Host == "" {
+		return nil, errors.New("zabbix: addr and host required")
+	}
+	zs := &zabbixSink{
+		addr:     cfg.Addr,
+		host:     cfg.Host,
+		prefix:   cfg.Prefix,
+		timeout:  time.Duration(cfg.Timeout) * time.Second,
+		interval: time.Duration(cfg.Discovery) * time.Second,
+	}
+	if zs.prefix == "" {
+		zs.prefix = "p2p"
+	}
+	if zs.timeout <= 0 {
+		zs.timeout = 10 * time.Second
+	}
+	if zs.interval <= 0 {
+		zs.interval = time.Hour
+	}
+	return zs, nil
+}
+
+func (zs *zabbixSink) Name() string { return "zabbix" }
+
+// discovery 与上次发出的不同或超过间隔时返回LLD项
+func (zs *zabbixSink) discovery() []zabbixItem {
+	lld := zabbixDiscovery(buildTopology())
+	changed := time.Since(zs.lastLLDAt) >= zs.interval
+	for typ, v := range lld {
+		if zs.lastLLD[typ] != v {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	var items []zabbixItem
+	for _, typ := range zabbixDiscoveryTypes {
+		items = append(items, zabbixItem{Host: zs.host, Key: zs.prefix + ".discovery[" + typ + "]", Value: lld[typ]})
+	}
+	zs.lastLLD, zs.lastLLDAt = lld, time.Now()
+	return items
+}
+
+// items 只输出scalar的sample
+func (zs *zabbixSink) items(ss []sample) []zabbixItem {
+	var items []zabbixItem
+	for i := range ss {
+		s := &ss[i]
+		if !s.scalar() {
+			continue
+		}
+		items = append(items, zabbixItem{
+			Host:  zs.host,
+			Key:   zabbixKey(zs.prefix, s),
+			Value: strconv.FormatFloat(s.Value, 'f', -1, 64),
+			Clock: s.Time.Unix(),
+			Ns:    s.Time.Nanosecond(),
+		})
+	}
+	return items
+}
+
+// send 报文为 "ZBXD\x01" + 8字节小端长度 + JSON, 响应格式相同
+func (zs *zabbixSink) send(items []zabbixItem) (*zabbixResponse, error) {
+	body, err := json.Marshal(zabbixRequest{Request: "sender data", Data: items})
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	var buf [8]byte
+	b.WriteString("ZBXD\x01")
+	binary.LittleEndian.PutUint64(buf[:], uint64(len(body)))
+	b.Write(buf[:])
+	b.Write(body)
+
+	conn, err := net.DialTimeout("tcp", zs.addr, zs.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(zs.timeout))
+	if _, err = conn.Write(b.Bytes()); err != nil {
+		return nil, err
+	}
+
+	var hdr [13]byte
+	if _, err = io.ReadFull(conn, hdr[:]); err != nil {
+		return nil, err
+	}
+	if string(hdr[:4]) != "ZBXD" {
+		return nil, errors.New("zabbix: invalid response header")
+	}
+	n := binary.LittleEndian.Uint64(hdr[5:])
+	if n > 1<<20 {
+		return nil, fmt.Errorf("zabbix: response too large: %d", n)
+	}
+	data := make([]byte, n)
+	if _, err = io.ReadFull(conn, data); err != nil {
+		return nil, err
+	}
+	rsp := &zabbixResponse{}
+	if err = json.Unmarshal(data, rsp); err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
+
+func (zs *zabbixSink) Write(ss []sample) error {
+	items := append(zs.discovery(), zs.items(ss)...)
+	if len(items) == 0 {
+		return nil
+	}
+	rsp, err := zs.send(items)
+	if err != nil {
+		zs.lastLLD = nil // 下次重发LLD
+		return err
+	}
+	if rsp.Response != "success" {
+		zs.lastLLD = nil
+		return fmt.Errorf("zabbix: %s %s", rsp.Response, rsp.Info)
+	}
+	// 监控项还没由LLD创建时会失败, 只计数
+	if m := zabbixFailed.FindStringSubmatch(rsp.Info); m != nil {
+		failed, _ := strconv.Atoi(m[1])
+		zabbix_failed.Add(float64(failed))
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+// zabbix_test
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stvp/assert"
+)
+
+// zabbixTrapper 模拟Zabbix server, 收到的请求发到reqs
+func zabbixTrapper(t *testing.T, ln net.Listener, reqs chan<- zabbixRequest) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		var hdr [13]byte
+		io.ReadFull(conn, hdr[:])
+		assert.Equal(t, "ZBXD\x01", string(hdr[:5]), "")
+		body := make([]byte, binary.LittleEndian.Uint64(hdr[5:]))
+		io.ReadFull(conn, body)
+		var req zabbixRequest
+		assert.Nil(t, json.Unmarshal(body, &req), "")
+
+		rsp, _ := json.Marshal(zabbixResponse{Response: "success", Info: "processed: 7; failed: 1; total: 8; seconds spent: 0.000055"})
+		var l [8]byte
+		binary.LittleEndian.PutUint64(l[:], uint64(len(rsp)))
+		conn.Write(append(append([]byte("ZBXD\x01"), l[:]...), rsp...))
+		conn.Close()
+		reqs <- req
+	}
+}
+
+func TestZabbixKey(t *testing.T) {
+	ss := cmSamples("1")
+	assert.Equal(t, "p2p.cm.onphone[1]", zabbixKey("p2p", &ss[0]), "")
+	ss[0].Labels = []label{{Name: "NodeID", Value: "a,b"}, {Name: "SvcType", Value: "4"}}
+	ss[0].Action, ss[0].Field = "serverSummary", "healthy"
+	assert.Equal(t, `p2p.serverSummary.healthy[host,"a,b"]`, zabbixKey("p2p", &ss[0]), "")
+}
+
+func TestZabbixSender(t *testing.T) {
+	commitRecords("cm",
+		"2017.07.04 14:45:41.639|1|10000|103.25.23.75|8000|10|3|7|20|1|2|3|4",
+	)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err, "")
+	defer ln.Close()
+	reqs := make(chan zabbixRequest, 2)
+	go zabbixTrapper(t, ln, reqs)
+
+	saved := globeCfg.Zabbix
+	defer func() { globeCfg.Zabbix = saved }()
+	globeCfg.Zabbix.Addr = ln.Addr().String()
+	globeCfg.Zabbix.Host = "p2p-vdn"
+	globeCfg.Zabbix.Prefix = ""
+	zs, err := newZabbixSink()
+	assert.Nil(t, err, "")
+
+	ss := cmSamples("1")
+	assert.Nil(t, zs.Write(ss), "")
+	req := <-reqs
+	assert.Equal(t, "sender data", req.Request, "")
+	assert.Equal(t, len(zabbixDiscoveryTypes)+8, len(req.Data), "")
+	var lld zabbixItem
+	for _, it := range req.Data {
+		assert.Equal(t, "p2p-vdn", it.Host, "")
+		if it.Key == "p2p.discovery[cm]" {
+			lld = it
+		}
+	}
+	assert.True(t, strings.Contains(lld.Value, `"{#HOSTID}":"10000"`), "")
+	assert.True(t, strings.Contains(lld.Value, `"{#NODEID}":"1"`), "")
+	last := req.Data[len(req.Data)-8]
+	assert.Equal(t, "p2p.cm.onphone[1]", last.Key, "")
+	assert.Equal(t, "10", last.Value, "")
+	assert.Equal(t, ss[0].Time.Unix(), last.Clock, "")
+
+	// 节点清单没变时不再发LLD
+	assert.Nil(t, zs.Write(ss), "")
+	req = <-reqs
+	assert.Equal(t, 8, len(req.Data), "")
+}